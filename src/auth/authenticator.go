@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+var (
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	ErrExpiredCredentials = errors.New("credentials expired")
+)
+
+// Authenticator resolves the value of an Authorization header, or of the
+// Authorization key of a connection_init payload, to a principal.
+type Authenticator interface {
+	Authenticate(ctx context.Context, authorization string) (*Principal, error)
+}
+
+// AuthenticatorFunc adapts a function to the Authenticator interface
+type AuthenticatorFunc func(ctx context.Context, authorization string) (*Principal, error)
+
+func (f AuthenticatorFunc) Authenticate(ctx context.Context, authorization string) (*Principal, error) {
+	return f(ctx, authorization)
+}
+
+// Chain tries each authenticator in turn and returns the first principal
+// found. When none succeeds, the first error more specific than
+// ErrInvalidCredentials is returned, such as ErrExpiredCredentials.
+func Chain(authenticators ...Authenticator) Authenticator {
+	return AuthenticatorFunc(func(ctx context.Context, authorization string) (*Principal, error) {
+		result := ErrInvalidCredentials
+		for _, a := range authenticators {
+			p, err := a.Authenticate(ctx, authorization)
+			if err == nil {
+				return p, nil
+			}
+			if result == ErrInvalidCredentials && !errors.Is(err, ErrInvalidCredentials) {
+				result = err
+			}
+		}
+		return nil, result
+	})
+}
+
+// StaticTokens authenticates API tokens against a fixed set of principals,
+// principals without a subject are rejected
+type StaticTokens map[string]*Principal
+
+func (t StaticTokens) Authenticate(ctx context.Context, authorization string) (*Principal, error) {
+	if p, ok := t[bearerToken(authorization)]; ok && p != nil && p.Subject != "" {
+		return p, nil
+	}
+	return nil, ErrInvalidCredentials
+}
+
+// bearerToken strips the optional "Bearer " scheme of an authorization value
+func bearerToken(authorization string) string {
+	if len(authorization) > 7 && strings.EqualFold(authorization[:7], "bearer ") {
+		return strings.TrimSpace(authorization[7:])
+	}
+	return strings.TrimSpace(authorization)
+}
@@ -0,0 +1,166 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/graph-gophers/graphql-go/types"
+	"github.com/vektah/gqlparser/v2/ast"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+	"github.com/vektah/gqlparser/v2/parser"
+)
+
+// Authorizer enforces the @auth(requires: ROLE) directives a schema declares on
+// its fields. The directive is declared in the schema as
+//
+//	directive @auth(requires: Role!) on FIELD_DEFINITION
+//
+// An operation selecting a field that requires a role the principal of ctx
+// wasn't granted is rejected as a whole, before any resolver runs.
+type Authorizer struct {
+	schema *types.Schema
+}
+
+func NewAuthorizer(schema *graphql.Schema) *Authorizer {
+	return &Authorizer{schema: schema.ASTSchema()}
+}
+
+// Authorize checks the fields operationName of document selects. Documents it
+// can't check, because they don't parse or don't name a single operation, are
+// denied: executors may accept what the checker can't read. Unknown fields are
+// left for the executor to reject.
+func (a *Authorizer) Authorize(ctx context.Context, document string, operationName string) *gqlerror.Error {
+	doc, err := parser.ParseQuery(&ast.Source{Input: document})
+	if err != nil {
+		return gqlerror.Errorf("unable to authorize the operation: %s", err)
+	}
+
+	op := operation(doc, operationName)
+	if op == nil {
+		return gqlerror.Errorf("unable to authorize the operation: no single operation named %q", operationName)
+	}
+	root, ok := a.schema.EntryPoints[string(op.Operation)]
+	if !ok {
+		return gqlerror.Errorf("unable to authorize the operation: the schema has no %s type", op.Operation)
+	}
+
+	w := walker{
+		schema:    a.schema,
+		doc:       doc,
+		principal: GetPrincipal(ctx),
+		visited:   map[string]bool{},
+	}
+	return w.selectionSet(root, op.SelectionSet, nil)
+}
+
+func operation(doc *ast.QueryDocument, name string) *ast.OperationDefinition {
+	if name == "" {
+		if len(doc.Operations) != 1 {
+			return nil
+		}
+		return doc.Operations[0]
+	}
+	return doc.Operations.ForName(name)
+}
+
+type walker struct {
+	schema    *types.Schema
+	doc       *ast.QueryDocument
+	principal *Principal
+	// fragments already checked, a fragment spread twice is checked once
+	visited map[string]bool
+}
+
+func (w *walker) selectionSet(t types.NamedType, set ast.SelectionSet, path ast.Path) *gqlerror.Error {
+	for _, sel := range set {
+		switch sel := sel.(type) {
+		case *ast.Field:
+			def := field(t, sel.Name)
+			if def == nil {
+				continue
+			}
+			fieldPath := append(append(ast.Path{}, path...), ast.PathName(sel.Alias))
+			if err := w.authorize(t, def, sel, fieldPath); err != nil {
+				return err
+			}
+			if err := w.selectionSet(named(def.Type), sel.SelectionSet, fieldPath); err != nil {
+				return err
+			}
+		case *ast.InlineFragment:
+			fragmentType := t
+			if sel.TypeCondition != "" {
+				fragmentType = w.schema.Types[sel.TypeCondition]
+			}
+			if err := w.selectionSet(fragmentType, sel.SelectionSet, path); err != nil {
+				return err
+			}
+		case *ast.FragmentSpread:
+			if w.visited[sel.Name] {
+				continue
+			}
+			w.visited[sel.Name] = true
+			fragment := w.doc.Fragments.ForName(sel.Name)
+			if fragment == nil {
+				continue
+			}
+			if err := w.selectionSet(w.schema.Types[fragment.TypeCondition], fragment.SelectionSet, path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (w *walker) authorize(t types.NamedType, def *types.FieldDefinition, sel *ast.Field, path ast.Path) *gqlerror.Error {
+	directive := def.Directives.Get("auth")
+	if directive == nil {
+		return nil
+	}
+	requires, ok := directive.Arguments.Get("requires")
+	if !ok {
+		return nil
+	}
+	role := requires.String()
+
+	if w.principal.HasRole(role) {
+		return nil
+	}
+
+	return &gqlerror.Error{
+		Message:    fmt.Sprintf("%s.%s requires role %s", t.TypeName(), def.Name, role),
+		Path:       path,
+		Locations:  []gqlerror.Location{{Line: sel.Position.Line, Column: sel.Position.Column}},
+		Extensions: map[string]interface{}{"code": denialCode(w.principal)},
+	}
+}
+
+// field looks up the definition of name on object and interface types, nil for
+// any other type or meta fields such as __typename.
+func field(t types.NamedType, name string) *types.FieldDefinition {
+	if strings.HasPrefix(name, "__") {
+		return nil
+	}
+	switch t := t.(type) {
+	case *types.ObjectTypeDefinition:
+		return t.Fields.Get(name)
+	case *types.InterfaceTypeDefinition:
+		return t.Fields.Get(name)
+	}
+	return nil
+}
+
+func named(t types.Type) types.NamedType {
+	for {
+		switch wrapper := t.(type) {
+		case *types.NonNull:
+			t = wrapper.OfType
+		case *types.List:
+			t = wrapper.OfType
+		default:
+			n, _ := t.(types.NamedType)
+			return n
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"context"
+	"testing"
+
+	graphql "github.com/graph-gophers/graphql-go"
+)
+
+const testSchema = `
+directive @auth(requires: Role!) on FIELD_DEFINITION
+
+enum Role {
+  ADMIN
+}
+
+schema {
+  query: Query
+  mutation: Mutation
+}
+
+type Query {
+  hello: String!
+  secret: String! @auth(requires: ADMIN)
+}
+
+type Mutation {
+  terminateConnection(id: String!): Boolean! @auth(requires: ADMIN)
+}
+`
+
+func TestAuthorize(t *testing.T) {
+	a := NewAuthorizer(graphql.MustParseSchema(testSchema, nil))
+	admin := WithPrincipal(context.Background(), &Principal{Subject: "root", Roles: []string{"admin"}})
+	reader := WithPrincipal(context.Background(), &Principal{Subject: "alice"})
+
+	tests := []struct {
+		name          string
+		ctx           context.Context
+		document      string
+		operationName string
+		allowed       bool
+	}{
+		{name: "open field", ctx: reader, document: `{ hello }`, allowed: true},
+		{name: "granted role", ctx: admin, document: `{ secret }`, allowed: true},
+		{name: "missing role", ctx: reader, document: `{ secret }`},
+		{name: "anonymous", ctx: context.Background(), document: `mutation { terminateConnection(id: "x") }`},
+		{name: "fragment", ctx: reader, document: `{ ...F } fragment F on Query { secret }`},
+		{name: "named operation", ctx: reader, document: `query A { hello } query B { secret }`, operationName: "B"},
+		// the executor skips what the parser of the authorizer rejects
+		{name: "unparsable", ctx: reader, document: `mutation { terminateConnection(id: "x") } /* */`},
+		{name: "ambiguous operation", ctx: reader, document: `query A { hello } query B { secret }`},
+		{name: "unknown operation", ctx: reader, document: `query A { hello }`, operationName: "B"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := a.Authorize(tt.ctx, tt.document, tt.operationName)
+			if tt.allowed && err != nil {
+				t.Errorf("denied: %s", err)
+			}
+			if !tt.allowed && err == nil {
+				t.Error("allowed")
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"hash"
+	"strings"
+	"time"
+)
+
+// HMACJWT authenticates HS256, HS384 and HS512 signed JWTs. The sub claim is
+// the principal subject, tokens without one are rejected, and the roles claim,
+// a list of strings, its roles.
+type HMACJWT struct {
+	// Keys maps a key id to its secret. A token with a kid header is checked
+	// against that key only, other tokens against every key.
+	Keys map[string][]byte
+	// Leeway is the clock skew tolerated on exp and nbf
+	Leeway time.Duration
+	// Now returns the current time, time.Now when nil
+	Now func() time.Time
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type jwtClaims struct {
+	Sub   string      `json:"sub"`
+	Exp   json.Number `json:"exp"`
+	Nbf   json.Number `json:"nbf"`
+	Roles []string    `json:"roles"`
+}
+
+func (a HMACJWT) Authenticate(ctx context.Context, authorization string) (*Principal, error) {
+	parts := strings.Split(bearerToken(authorization), ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidCredentials
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	var alg func() hash.Hash
+	switch header.Alg {
+	case "HS256":
+		alg = sha256.New
+	case "HS384":
+		alg = sha512.New384
+	case "HS512":
+		alg = sha512.New
+	default:
+		return nil, ErrInvalidCredentials
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	if !a.verify(header.Kid, alg, parts[0]+"."+parts[1], signature) {
+		return nil, ErrInvalidCredentials
+	}
+
+	var claims jwtClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	// principals are told apart by subject, such as channel members
+	if claims.Sub == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	now := time.Now()
+	if a.Now != nil {
+		now = a.Now()
+	}
+	p := &Principal{Subject: claims.Sub, Roles: claims.Roles}
+	if claims.Exp != "" {
+		exp, err := claims.Exp.Int64()
+		if err != nil {
+			return nil, ErrInvalidCredentials
+		}
+		// the leeway is part of the expiry, connections authenticated with
+		// the token stay open as long as it is accepted
+		p.ExpiresAt = time.Unix(exp, 0).Add(a.Leeway)
+		if now.After(p.ExpiresAt) {
+			return nil, ErrExpiredCredentials
+		}
+	}
+	if claims.Nbf != "" {
+		nbf, err := claims.Nbf.Int64()
+		if err != nil || now.Add(a.Leeway).Before(time.Unix(nbf, 0)) {
+			return nil, ErrInvalidCredentials
+		}
+	}
+
+	return p, nil
+}
+
+func (a HMACJWT) verify(kid string, alg func() hash.Hash, signed string, signature []byte) bool {
+	if kid != "" {
+		key, ok := a.Keys[kid]
+		return ok && checkMAC(alg, key, signed, signature)
+	}
+
+	for _, key := range a.Keys {
+		if checkMAC(alg, key, signed, signature) {
+			return true
+		}
+	}
+	return false
+}
+
+func checkMAC(alg func() hash.Hash, key []byte, signed string, signature []byte) bool {
+	mac := hmac.New(alg, key)
+	mac.Write([]byte(signed))
+	return hmac.Equal(mac.Sum(nil), signature)
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func signJWT(t *testing.T, secret string, claims map[string]interface{}) string {
+	t.Helper()
+
+	header, err := json.Marshal(map[string]string{"alg": "HS256", "typ": "JWT"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestHMACJWTExpiryIncludesLeeway(t *testing.T) {
+	exp := time.Unix(1700000000, 0)
+	now := exp
+	a := HMACJWT{
+		Keys:   map[string][]byte{"k1": []byte("secret")},
+		Leeway: 30 * time.Second,
+		Now:    func() time.Time { return now },
+	}
+	token := "Bearer " + signJWT(t, "secret", map[string]interface{}{"sub": "alice", "exp": exp.Unix()})
+
+	// within the leeway the token is accepted, and the connections it
+	// authenticates must stay open until the leeway runs out
+	now = exp.Add(10 * time.Second)
+	p, err := a.Authenticate(context.Background(), token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := exp.Add(a.Leeway); !p.ExpiresAt.Equal(want) {
+		t.Errorf("expires at %s, want %s", p.ExpiresAt, want)
+	}
+
+	now = p.ExpiresAt.Add(time.Second)
+	if _, err := a.Authenticate(context.Background(), token); !errors.Is(err, ErrExpiredCredentials) {
+		t.Errorf("got %v after the leeway, want ErrExpiredCredentials", err)
+	}
+}
+
+func TestPrincipalsNeedASubject(t *testing.T) {
+	a := HMACJWT{Keys: map[string][]byte{"k1": []byte("secret")}}
+	for _, claims := range []map[string]interface{}{{}, {"sub": ""}, {"roles": []string{"ADMIN"}}} {
+		if p, err := a.Authenticate(context.Background(), signJWT(t, "secret", claims)); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("claims %v: got %+v, %v", claims, p, err)
+		}
+	}
+	if _, err := a.Authenticate(context.Background(), signJWT(t, "secret", map[string]interface{}{"sub": "alice"})); err != nil {
+		t.Error(err)
+	}
+
+	static := StaticTokens{"anonymous": {Roles: []string{"ADMIN"}}, "alice": {Subject: "alice"}}
+	if p, err := static.Authenticate(context.Background(), "Bearer anonymous"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("got %+v, %v for a token without subject", p, err)
+	}
+	if _, err := static.Authenticate(context.Background(), "Bearer alice"); err != nil {
+		t.Error(err)
+	}
+}
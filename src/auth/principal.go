@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Principal is the identity a request or websocket connection authenticated
+// as.
+type Principal struct {
+	Subject string
+	Roles   []string
+	// ExpiresAt is when the authenticator stops accepting the credentials,
+	// tolerated clock skew included. It is the zero time when the credentials
+	// never expire.
+	ExpiresAt time.Time
+}
+
+// HasRole reports whether the principal was granted role. Roles compare case
+// insensitively so the Role enum of the schema matches lowercase role claims.
+func (p *Principal) HasRole(role string) bool {
+	if p == nil {
+		return false
+	}
+	for _, r := range p.Roles {
+		if strings.EqualFold(r, role) {
+			return true
+		}
+	}
+	return false
+}
+
+// RoleError rejects a request whose principal wasn't granted Role. Code is
+// UNAUTHENTICATED for anonymous requests and FORBIDDEN otherwise, graphql-go
+// reports it in the extensions of the error.
+type RoleError struct {
+	Role string
+	Code string
+}
+
+func (e *RoleError) Error() string {
+	return fmt.Sprintf("requires role %s", e.Role)
+}
+
+func (e *RoleError) Extensions() map[string]interface{} {
+	return map[string]interface{}{"code": e.Code}
+}
+
+// RequireRole returns a RoleError unless the principal of ctx was granted role.
+// Resolvers of fields that must stay closed when no Authorizer enforces the
+// @auth directives call it themselves.
+func RequireRole(ctx context.Context, role string) error {
+	p := GetPrincipal(ctx)
+	if p.HasRole(role) {
+		return nil
+	}
+	return &RoleError{Role: role, Code: denialCode(p)}
+}
+
+func denialCode(p *Principal) string {
+	if p == nil {
+		return "UNAUTHENTICATED"
+	}
+	return "FORBIDDEN"
+}
+
+// A private key for context that only this package can access. This is important
+// to prevent collisions between different context uses
+var principalCtxKey = &principalContextKey{"principal"}
+
+type principalContextKey struct {
+	name string
+}
+
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalCtxKey, p)
+}
+
+// GetPrincipal returns the principal of the request, or nil for anonymous
+// requests.
+func GetPrincipal(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalCtxKey).(*Principal)
+	return p
+}
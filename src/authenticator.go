@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sample-subscription/src/auth"
+	"strings"
+)
+
+// newAuthenticator builds the authenticator configured by the environment, nil
+// when authentication is disabled.
+//
+//	AUTH_JWT_KEYS    comma separated "kid=secret" HMAC keys, the secret may
+//	                 contain "=" such as base64 padding
+//	AUTH_API_TOKENS  comma separated "token=subject:role1|role2" entries
+func newAuthenticator() (auth.Authenticator, error) {
+	var authenticators []auth.Authenticator
+
+	if keys := os.Getenv("AUTH_JWT_KEYS"); keys != "" {
+		jwtKeys, err := parseJWTKeys(keys)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, auth.HMACJWT{Keys: jwtKeys})
+	}
+
+	if tokens := os.Getenv("AUTH_API_TOKENS"); tokens != "" {
+		static, err := parseAPITokens(tokens)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, static)
+	}
+
+	switch len(authenticators) {
+	case 0:
+		return nil, nil
+	case 1:
+		return authenticators[0], nil
+	default:
+		return auth.Chain(authenticators...), nil
+	}
+}
+
+// parseJWTKeys parses the AUTH_JWT_KEYS entries. Every key is named, key ids
+// can't contain "=" so entries split on the first one.
+func parseJWTKeys(keys string) (map[string][]byte, error) {
+	parsed := map[string][]byte{}
+	for _, entry := range strings.Split(keys, ",") {
+		kid, secret, found := strings.Cut(entry, "=")
+		if !found || kid == "" || secret == "" {
+			return nil, fmt.Errorf("invalid jwt key entry, want kid=secret")
+		}
+		if _, ok := parsed[kid]; ok {
+			return nil, fmt.Errorf("duplicate jwt key id %q", kid)
+		}
+		parsed[kid] = []byte(secret)
+	}
+	return parsed, nil
+}
+
+// parseAPITokens parses the AUTH_API_TOKENS entries, every token needs a
+// subject.
+func parseAPITokens(tokens string) (auth.StaticTokens, error) {
+	static := auth.StaticTokens{}
+	for _, entry := range strings.Split(tokens, ",") {
+		token, identity, found := strings.Cut(entry, "=")
+		if !found || token == "" {
+			return nil, fmt.Errorf("invalid api token entry %q", entry)
+		}
+		subject, roles, _ := strings.Cut(identity, ":")
+		if subject == "" {
+			return nil, fmt.Errorf("api token entry %q has no subject", entry)
+		}
+		p := &auth.Principal{Subject: subject}
+		if roles != "" {
+			p.Roles = strings.Split(roles, "|")
+		}
+		static[token] = p
+	}
+	return static, nil
+}
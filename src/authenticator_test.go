@@ -0,0 +1,41 @@
+package main
+
+import "testing"
+
+func TestParseJWTKeys(t *testing.T) {
+	keys, err := parseJWTKeys("k1=c2VjcmV0,k2=c2VjcmV0Mg==")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(keys["k1"]); got != "c2VjcmV0" {
+		t.Errorf("k1 secret %q", got)
+	}
+	if got := string(keys["k2"]); got != "c2VjcmV0Mg==" {
+		t.Errorf("padded k2 secret %q", got)
+	}
+
+	for _, invalid := range []string{"c2VjcmV0", "=secret", "k1=", "k1=a,k1=b"} {
+		if _, err := parseJWTKeys(invalid); err == nil {
+			t.Errorf("accepted %q", invalid)
+		}
+	}
+}
+
+func TestParseAPITokens(t *testing.T) {
+	tokens, err := parseAPITokens("t1=alice:ADMIN|WRITER,t2=bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := tokens["t1"]; p.Subject != "alice" || len(p.Roles) != 2 || p.Roles[0] != "ADMIN" || p.Roles[1] != "WRITER" {
+		t.Errorf("t1 principal %+v", p)
+	}
+	if p := tokens["t2"]; p.Subject != "bob" || len(p.Roles) != 0 {
+		t.Errorf("t2 principal %+v", p)
+	}
+
+	for _, invalid := range []string{"t1", "=alice", "t1=", "t1=:ADMIN"} {
+		if _, err := parseAPITokens(invalid); err == nil {
+			t.Errorf("accepted %q", invalid)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"sample-subscription/src/auth"
+	"sample-subscription/src/core/modules/message"
+	"sample-subscription/src/subscription/transport"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/nats-io/nats.go"
+	"github.com/redis/go-redis/v9"
+)
+
+// newBroker builds the broker selected by the BROKER environment variable, the
+// in-memory broker by default.
+func newBroker(ctx context.Context) (message.Broker, error) {
+	bp, err := backpressure()
+	if err != nil {
+		return nil, err
+	}
+	opts := []message.BrokerOption{message.WithBackpressure(bp)}
+
+	switch backend := os.Getenv("BROKER"); backend {
+	case "", "memory":
+		broker := message.NewMemoryBroker(opts...)
+		go broker.BroadcastMessageEvent()
+		return broker, nil
+	case "redis":
+		addr := getenv("REDIS_ADDR", "localhost:6379")
+		broker := message.NewRedisBroker(redis.NewClient(&redis.Options{Addr: addr}), getenv("REDIS_CHANNEL", "messages"), opts...)
+		go func() {
+			if err := broker.Run(ctx); err != nil && ctx.Err() == nil {
+				log.Fatalf("redis broker: %s", err)
+			}
+		}()
+		return broker, nil
+	case "nats":
+		conn, err := nats.Connect(getenv("NATS_URL", nats.DefaultURL))
+		if err != nil {
+			return nil, err
+		}
+		subject := getenv("NATS_SUBJECT", "messages")
+		stream := os.Getenv("NATS_STREAM")
+		if stream == "" {
+			return message.NewNatsBroker(conn, subject, opts...), nil
+		}
+		broker, err := message.NewJetStreamBroker(conn, stream, subject, opts...)
+		if err != nil {
+			return nil, err
+		}
+		broker.DurableName = func(ctx context.Context) string {
+			return durableConsumerName(auth.GetPrincipal(ctx), transport.GetInitPayload(ctx).GetString("consumer"))
+		}
+		if threshold := os.Getenv("NATS_CONSUMER_INACTIVE_THRESHOLD"); threshold != "" {
+			if broker.InactiveThreshold, err = time.ParseDuration(threshold); err != nil {
+				return nil, err
+			}
+		}
+		return broker, nil
+	case "postgres":
+		pool, err := pgxpool.New(ctx, os.Getenv("DATABASE_URL"))
+		if err != nil {
+			return nil, err
+		}
+		broker, err := message.NewPostgresBroker(ctx, pool, getenv("POSTGRES_CHANNEL", "messages"), getenv("POSTGRES_PAYLOAD_TABLE", "message_payloads"), opts...)
+		if err != nil {
+			return nil, err
+		}
+		if ttl := os.Getenv("POSTGRES_PAYLOAD_TTL"); ttl != "" {
+			if broker.PayloadTTL, err = time.ParseDuration(ttl); err != nil {
+				return nil, err
+			}
+		}
+		go func() {
+			if err := broker.Run(ctx); err != nil && ctx.Err() == nil {
+				log.Fatalf("postgres broker: %s", err)
+			}
+		}()
+		return broker, nil
+	default:
+		return nil, fmt.Errorf("unsupported broker %q", backend)
+	}
+}
+
+// backpressure reads the subscriber queue configuration of the brokers
+// delivering to local subscribers.
+func backpressure() (message.Backpressure, error) {
+	bp := message.DefaultBackpressure
+
+	if policy := os.Getenv("BACKPRESSURE_POLICY"); policy != "" {
+		var err error
+		if bp.Policy, err = message.ParseBackpressurePolicy(policy); err != nil {
+			return bp, err
+		}
+	}
+	if size := os.Getenv("BACKPRESSURE_QUEUE_SIZE"); size != "" {
+		var err error
+		if bp.QueueSize, err = strconv.Atoi(size); err != nil {
+			return bp, err
+		}
+		// the queue holds the events of a subscriber until they are delivered
+		if bp.QueueSize < 1 {
+			return bp, fmt.Errorf("invalid BACKPRESSURE_QUEUE_SIZE %d, it must be at least 1", bp.QueueSize)
+		}
+	}
+	if timeout := os.Getenv("BACKPRESSURE_TIMEOUT"); timeout != "" {
+		var err error
+		if bp.Timeout, err = time.ParseDuration(timeout); err != nil {
+			return bp, err
+		}
+	}
+
+	return bp, nil
+}
+
+// durableConsumerName lets authenticated clients opt into a durable consumer
+// with a "consumer" key in the connection_init payload. The name is scoped to
+// the principal so clients can't bind to each other's consumers, anonymous
+// clients only get ephemeral consumers.
+func durableConsumerName(p *auth.Principal, consumer string) string {
+	if consumer == "" || p == nil {
+		return ""
+	}
+
+	// subjects may hold characters consumer names can't, such as dots
+	subject := sha256.Sum256([]byte(p.Subject))
+	return hex.EncodeToString(subject[:16]) + "-" + consumer
+}
+
+func getenv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"sample-subscription/src/auth"
+	"testing"
+)
+
+func TestDurableConsumerName(t *testing.T) {
+	alice := &auth.Principal{Subject: "alice@example.com"}
+	bob := &auth.Principal{Subject: "bob@example.com"}
+
+	if name := durableConsumerName(nil, "phone"); name != "" {
+		t.Errorf("anonymous clients got durable consumer %q", name)
+	}
+	if name := durableConsumerName(alice, ""); name != "" {
+		t.Errorf("got durable consumer %q without opting in", name)
+	}
+
+	name := durableConsumerName(alice, "phone")
+	if name != durableConsumerName(alice, "phone") {
+		t.Error("names aren't stable")
+	}
+	if name == durableConsumerName(bob, "phone") {
+		t.Error("principals share a consumer")
+	}
+	if name == durableConsumerName(alice, "laptop") {
+		t.Error("consumers of a principal share a name")
+	}
+}
+
+func TestBackpressureQueueSizeIsValidated(t *testing.T) {
+	for _, size := range []string{"0", "-1", "many"} {
+		t.Setenv("BACKPRESSURE_QUEUE_SIZE", size)
+		if bp, err := backpressure(); err == nil {
+			t.Errorf("accepted queue size %s: %+v", size, bp)
+		}
+	}
+
+	t.Setenv("BACKPRESSURE_QUEUE_SIZE", "1")
+	if bp, err := backpressure(); err != nil || bp.QueueSize != 1 {
+		t.Errorf("got %+v, %v", bp, err)
+	}
+}
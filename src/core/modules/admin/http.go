@@ -0,0 +1,62 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"sample-subscription/src/core/modules/message"
+	"sample-subscription/src/subscription/transport"
+)
+
+// NewHandler returns the admin HTTP API:
+//
+//	GET  /subscriptions
+//	GET  /connections
+//	POST /subscriptions/terminate?connectionId=...&operationId=...
+//	POST /connections/terminate?id=...
+func NewHandler(subscriptions *message.SubscriptionRegistry, connections *transport.Registry) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/subscriptions", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, subscriptions.List())
+	})
+	mux.HandleFunc("/connections", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, connections.Connections())
+	})
+	mux.HandleFunc("/subscriptions/terminate", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !connections.StopOperation(r.URL.Query().Get("connectionId"), r.URL.Query().Get("operationId")) {
+			http.Error(w, "subscription not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("/connections/terminate", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !connections.CloseConnection(r.URL.Query().Get("id")) {
+			http.Error(w, "connection not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,119 @@
+package admin
+
+import (
+	"context"
+	"math"
+	"sample-subscription/src/auth"
+	"sample-subscription/src/core/modules/message"
+	"sample-subscription/src/subscription/transport"
+
+	graphql "github.com/graph-gophers/graphql-go"
+)
+
+// AdminResolver exposes the running subscriptions, websocket connections and
+// HTTP streams to operators. Its fields require the ADMIN role even when no Authorizer enforces
+// the schema directives, anonymous requests are rejected.
+type AdminResolver struct {
+	subscriptions *message.SubscriptionRegistry
+	connections   *transport.Registry
+}
+
+func NewAdminResolver(subscriptions *message.SubscriptionRegistry, connections *transport.Registry) AdminResolver {
+	return AdminResolver{
+		subscriptions: subscriptions,
+		connections:   connections,
+	}
+}
+
+const adminRole = "ADMIN"
+
+type SubscriptionInfo struct {
+	Id           string
+	ConnectionId string
+	OperationId  string
+	Channel      string
+	Filter       string
+	StartedAt    graphql.Time
+	Delivered    int32
+	Dropped      int32
+}
+
+type ConnectionInfo struct {
+	Id          string
+	RemoteAddr  string
+	Subprotocol string
+	StartedAt   graphql.Time
+	Operations  []string
+}
+
+func (r AdminResolver) Subscriptions(ctx context.Context) ([]*SubscriptionInfo, error) {
+	if err := auth.RequireRole(ctx, adminRole); err != nil {
+		return nil, err
+	}
+	if r.subscriptions == nil {
+		return []*SubscriptionInfo{}, nil
+	}
+
+	infos := r.subscriptions.List()
+	subscriptions := make([]*SubscriptionInfo, len(infos))
+	for i, info := range infos {
+		subscriptions[i] = &SubscriptionInfo{
+			Id:           info.Id,
+			ConnectionId: info.ConnectionId,
+			OperationId:  info.OperationId,
+			Channel:      info.Channel,
+			Filter:       info.Filter,
+			StartedAt:    graphql.Time{Time: info.StartedAt},
+			Delivered:    clamp(info.Delivered),
+			Dropped:      clamp(info.Dropped),
+		}
+	}
+	return subscriptions, nil
+}
+
+func (r AdminResolver) Connections(ctx context.Context) ([]*ConnectionInfo, error) {
+	if err := auth.RequireRole(ctx, adminRole); err != nil {
+		return nil, err
+	}
+	if r.connections == nil {
+		return []*ConnectionInfo{}, nil
+	}
+
+	infos := r.connections.Connections()
+	connections := make([]*ConnectionInfo, len(infos))
+	for i, info := range infos {
+		connections[i] = &ConnectionInfo{
+			Id:          info.ID,
+			RemoteAddr:  info.RemoteAddr,
+			Subprotocol: info.Subprotocol,
+			StartedAt:   graphql.Time{Time: info.StartedAt},
+			Operations:  info.Operations,
+		}
+	}
+	return connections, nil
+}
+
+func (r AdminResolver) TerminateSubscription(ctx context.Context, input struct {
+	ConnectionId string
+	OperationId  string
+}) (bool, error) {
+	if err := auth.RequireRole(ctx, adminRole); err != nil {
+		return false, err
+	}
+	return r.connections != nil && r.connections.StopOperation(input.ConnectionId, input.OperationId), nil
+}
+
+func (r AdminResolver) TerminateConnection(ctx context.Context, input struct{ Id string }) (bool, error) {
+	if err := auth.RequireRole(ctx, adminRole); err != nil {
+		return false, err
+	}
+	return r.connections != nil && r.connections.CloseConnection(input.Id), nil
+}
+
+// clamp fits a counter in a GraphQL Int
+func clamp(n uint64) int32 {
+	if n > math.MaxInt32 {
+		return math.MaxInt32
+	}
+	return int32(n)
+}
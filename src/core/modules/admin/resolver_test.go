@@ -0,0 +1,57 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"sample-subscription/src/auth"
+	"sample-subscription/src/core/modules/message"
+	"sample-subscription/src/subscription/transport"
+	"testing"
+)
+
+func TestAdminFieldsRequireAdminRole(t *testing.T) {
+	r := NewAdminResolver(message.NewSubscriptionRegistry(), transport.NewRegistry())
+
+	tests := []struct {
+		name      string
+		principal *auth.Principal
+		code      string
+	}{
+		{name: "anonymous", code: "UNAUTHENTICATED"},
+		{name: "writer", principal: &auth.Principal{Subject: "alice", Roles: []string{"writer"}}, code: "FORBIDDEN"},
+		{name: "admin", principal: &auth.Principal{Subject: "root", Roles: []string{"admin"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.principal != nil {
+				ctx = auth.WithPrincipal(ctx, tt.principal)
+			}
+
+			_, subscriptionsErr := r.Subscriptions(ctx)
+			_, connectionsErr := r.Connections(ctx)
+			_, terminateSubscriptionErr := r.TerminateSubscription(ctx, struct {
+				ConnectionId string
+				OperationId  string
+			}{"c", "o"})
+			_, terminateConnectionErr := r.TerminateConnection(ctx, struct{ Id string }{"c"})
+
+			for _, err := range []error{subscriptionsErr, connectionsErr, terminateSubscriptionErr, terminateConnectionErr} {
+				if tt.code == "" {
+					if err != nil {
+						t.Errorf("unexpected error: %s", err)
+					}
+					continue
+				}
+				var roleErr *auth.RoleError
+				if !errors.As(err, &roleErr) {
+					t.Errorf("got %v, want a RoleError", err)
+					continue
+				}
+				if code := roleErr.Extensions()["code"]; code != tt.code {
+					t.Errorf("code %v, want %s", code, tt.code)
+				}
+			}
+		})
+	}
+}
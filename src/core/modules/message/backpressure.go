@@ -0,0 +1,223 @@
+package message
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// BackpressurePolicy decides what the broadcaster does with an event for a
+// subscriber whose queue is full.
+type BackpressurePolicy int
+
+const (
+	// DropOldest discards the oldest queued event to make room for the new one
+	DropOldest BackpressurePolicy = iota
+	// DropNewest discards the new event
+	DropNewest
+	// BlockWithTimeout waits up to Backpressure.Timeout for room in the queue
+	// then discards the new event. The broadcaster is held meanwhile, which
+	// delays every other subscriber.
+	BlockWithTimeout
+	// DisconnectSlowConsumer ends the subscription, its events channel is
+	// closed once the queued events are delivered
+	DisconnectSlowConsumer
+)
+
+func (p BackpressurePolicy) String() string {
+	switch p {
+	case DropOldest:
+		return "drop-oldest"
+	case DropNewest:
+		return "drop-newest"
+	case BlockWithTimeout:
+		return "block"
+	case DisconnectSlowConsumer:
+		return "disconnect"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseBackpressurePolicy parses the String form of a policy.
+func ParseBackpressurePolicy(text string) (BackpressurePolicy, error) {
+	for _, p := range []BackpressurePolicy{DropOldest, DropNewest, BlockWithTimeout, DisconnectSlowConsumer} {
+		if p.String() == text {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown backpressure policy %q", text)
+}
+
+// Backpressure configures the queue each subscriber of a MemoryBroker gets.
+type Backpressure struct {
+	Policy    BackpressurePolicy
+	QueueSize int
+	// Timeout is only used by BlockWithTimeout
+	Timeout time.Duration
+}
+
+var DefaultBackpressure = Backpressure{
+	Policy:    DropOldest,
+	QueueSize: 64,
+	Timeout:   time.Second,
+}
+
+// subscriberQueue buffers the events of a subscriber, a single goroutine
+// delivers them so they reach the subscriber in the order they were queued.
+type subscriberQueue struct {
+	s      *OnMessageSubscriber
+	events chan *Message
+	// quit stops delivery, disconnect is set beforehand when the subscriber
+	// is dropped for being too slow
+	quit       chan struct{}
+	disconnect bool
+	// release is called with every event leaving the queue, delivered or
+	// dropped, when set
+	release func(e *Message)
+
+	// missed counts the events dropped since the last delivered event
+	missed atomic.Int32
+}
+
+func newSubscriberQueue(s *OnMessageSubscriber, size int) *subscriberQueue {
+	return &subscriberQueue{
+		s:      s,
+		events: make(chan *Message, size),
+		quit:   make(chan struct{}),
+	}
+}
+
+func (q *subscriberQueue) drop(e *Message) {
+	q.s.dropped.Add(1)
+	q.missed.Add(1)
+	if q.release != nil {
+		q.release(e)
+	}
+}
+
+// push queues e following the policy, it returns false when the subscriber
+// must be disconnected.
+func (q *subscriberQueue) push(e *Message, bp Backpressure) bool {
+	select {
+	case q.events <- e:
+		return true
+	default:
+	}
+
+	switch bp.Policy {
+	case DropOldest:
+		// queues have a single producer, once an event is taken out the new
+		// one fits
+		select {
+		case oldest := <-q.events:
+			q.drop(oldest)
+		default:
+		}
+		select {
+		case q.events <- e:
+		default:
+			q.drop(e)
+		}
+	case DropNewest:
+		q.drop(e)
+	case BlockWithTimeout:
+		select {
+		case q.events <- e:
+		case <-time.After(bp.Timeout):
+			q.drop(e)
+		}
+	case DisconnectSlowConsumer:
+		q.drop(e)
+		return false
+	}
+	return true
+}
+
+// deliver forwards queued events to the subscriber until it stops or the
+// queue is closed, unsubscribe is called when the subscriber stops by itself.
+func (q *subscriberQueue) deliver(unsubscribe func(id string)) {
+	for {
+		select {
+		case <-q.quit:
+			q.stop(nil)
+			return
+		case <-q.s.Stop:
+			unsubscribe(q.s.Id)
+			return
+		case e := <-q.events:
+			switch q.send(e, q.quit) {
+			case sendQuit:
+				q.stop(e)
+				return
+			case sendStopped:
+				unsubscribe(q.s.Id)
+				return
+			}
+		}
+	}
+}
+
+type sendResult int
+
+const (
+	sent sendResult = iota
+	sendQuit
+	sendStopped
+)
+
+// send delivers e to the subscriber along with the number of events it missed
+// since the previous one, dropped or never published, unless quit is closed or the subscriber stops first.
+func (q *subscriberQueue) send(e *Message, quit <-chan struct{}) sendResult {
+	missed := q.missed.Swap(0) + e.Skipped
+	out := e
+	if missed > 0 {
+		copied := *e
+		copied.Missed = missed
+		out = &copied
+	}
+
+	select {
+	case q.s.Events <- out:
+		q.s.delivered.Add(1)
+		if q.release != nil {
+			q.release(e)
+		}
+		return sent
+	case <-quit:
+	case <-q.s.Stop:
+	}
+
+	// not delivered, the count goes with the next event
+	q.missed.Add(missed)
+	select {
+	case <-q.s.Stop:
+		return sendStopped
+	default:
+		return sendQuit
+	}
+}
+
+// stop ends delivery, a disconnected subscriber still gets the events queued
+// before it fell behind, starting with inflight, then its channel is closed so
+// the subscription ends.
+func (q *subscriberQueue) stop(inflight *Message) {
+	if !q.disconnect {
+		return
+	}
+
+	if inflight != nil && q.send(inflight, nil) != sent {
+		return
+	}
+	for {
+		select {
+		case e := <-q.events:
+			if q.send(e, nil) != sent {
+				return
+			}
+		default:
+			close(q.s.Events)
+			return
+		}
+	}
+}
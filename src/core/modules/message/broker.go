@@ -0,0 +1,19 @@
+package message
+
+import "context"
+
+// Broker is the fan-out engine behind MessageResolver. SendMessage publishes
+// through it and every OnMessage subscription is registered on it.
+type Broker interface {
+	Publish(ctx context.Context, msg *Message) error
+	Subscribe(ctx context.Context, s *OnMessageSubscriber) (Subscription, error)
+}
+
+// Subscription is the handle returned by Broker.Subscribe. Cancel removes the
+// subscriber from the broker, it is safe to call more than once. Done is closed
+// once the broker stops delivering to the subscriber, when the broker is
+// closed for instance, it may stay open after Cancel.
+type Subscription interface {
+	Cancel()
+	Done() <-chan struct{}
+}
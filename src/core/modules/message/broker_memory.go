@@ -0,0 +1,182 @@
+package message
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"github.com/google/uuid"
+)
+
+// ErrBrokerClosed is returned by the operations of a closed broker
+var ErrBrokerClosed = errors.New("broker closed")
+
+// MemoryBroker is the default in-process Broker. Events and subscribers are
+// handed over channels to the BroadcastMessageEvent loop which must be running
+// for the broker to deliver anything.
+type MemoryBroker struct {
+	MessageEvents       chan *Message
+	HelloSaidSubscriber chan *OnMessageSubscriber
+	Unsubscribe         chan string
+	Backpressure        Backpressure
+
+	dropped   atomic.Uint64
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// BrokerOption applies configuration to a MemoryBroker, or to the local
+// MemoryBroker of the brokers fanning out received events. The NATS broker
+// takes the backpressure of the options.
+type BrokerOption func(*MemoryBroker)
+
+func WithBackpressure(bp Backpressure) BrokerOption {
+	return func(b *MemoryBroker) {
+		b.Backpressure = bp
+	}
+}
+
+var _ Broker = (*MemoryBroker)(nil)
+
+func NewMemoryBroker(opts ...BrokerOption) *MemoryBroker {
+	b := &MemoryBroker{
+		MessageEvents:       make(chan *Message),
+		HelloSaidSubscriber: make(chan *OnMessageSubscriber),
+		Unsubscribe:         make(chan string),
+		Backpressure:        DefaultBackpressure,
+		done:                make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// Dropped returns the number of events dropped by the backpressure policy
+// since the broker started.
+func (b *MemoryBroker) Dropped() uint64 {
+	return b.dropped.Load()
+}
+
+// Close stops the BroadcastMessageEvent loop. Subscribers get the events
+// already queued for them, then their Events channel is closed.
+func (b *MemoryBroker) Close() error {
+	b.closeOnce.Do(func() { close(b.done) })
+	return nil
+}
+
+// Publish hands msg to the broadcast loop, events are broadcast in the order
+// they are published.
+func (b *MemoryBroker) Publish(ctx context.Context, msg *Message) error {
+	select {
+	case b.MessageEvents <- msg:
+		return nil
+	case <-b.done:
+		return ErrBrokerClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (b *MemoryBroker) Subscribe(ctx context.Context, s *OnMessageSubscriber) (Subscription, error) {
+	if s.Id == "" {
+		s.Id = uuid.NewString()
+	}
+
+	// NOTE: this could take a while
+	select {
+	case b.HelloSaidSubscriber <- s:
+	case <-b.done:
+		return nil, ErrBrokerClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	return &memorySubscription{id: s.Id, unsubscribe: b.Unsubscribe, done: b.done}, nil
+}
+
+// BroadcastMessageEvent delivers published events to the subscribers until the
+// broker is closed.
+func (b *MemoryBroker) BroadcastMessageEvent() {
+	subscribers := map[string]*subscriberQueue{}
+	// channels indexes subscribers by channel then id, an event only visits
+	// the audience of its channel
+	channels := map[string]map[string]*subscriberQueue{}
+
+	remove := func(q *subscriberQueue) {
+		delete(subscribers, q.s.Id)
+		delete(channels[q.s.Channel], q.s.Id)
+		if len(channels[q.s.Channel]) == 0 {
+			delete(channels, q.s.Channel)
+		}
+		close(q.quit)
+	}
+	unsubscribe := func(id string) {
+		select {
+		case b.Unsubscribe <- id:
+		case <-b.done:
+		}
+	}
+
+	for {
+		select {
+		case <-b.done:
+			// subscribers end like slow consumers do, after their queued
+			// events
+			for _, q := range subscribers {
+				q.disconnect = true
+				remove(q)
+			}
+			return
+		case id := <-b.Unsubscribe:
+			if q, ok := subscribers[id]; ok {
+				remove(q)
+			}
+		case s := <-b.HelloSaidSubscriber:
+			q := newSubscriberQueue(s, b.Backpressure.QueueSize)
+			subscribers[s.Id] = q
+			if channels[s.Channel] == nil {
+				channels[s.Channel] = map[string]*subscriberQueue{}
+			}
+			channels[s.Channel][s.Id] = q
+			go q.deliver(unsubscribe)
+		case e := <-b.MessageEvents:
+			for _, q := range channels[e.Channel] {
+				if !q.s.matches(e) {
+					// Event does not match filter, skip sending
+					continue
+				}
+
+				dropped := q.s.Dropped()
+				if !q.push(e, b.Backpressure) {
+					q.disconnect = true
+					remove(q)
+				}
+				b.dropped.Add(q.s.Dropped() - dropped)
+			}
+		}
+	}
+}
+
+type memorySubscription struct {
+	id          string
+	unsubscribe chan<- string
+	done        <-chan struct{}
+	once        sync.Once
+}
+
+func (s *memorySubscription) Cancel() {
+	s.once.Do(func() {
+		go func() {
+			select {
+			case s.unsubscribe <- s.id:
+			case <-s.done:
+			}
+		}()
+	})
+}
+
+func (s *memorySubscription) Done() <-chan struct{} {
+	return s.done
+}
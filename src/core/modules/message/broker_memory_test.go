@@ -0,0 +1,115 @@
+package message
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// publishSeqs publishes the seqs 1 to n on the general channel
+func publishSeqs(t *testing.T, b Broker, n int32) {
+	t.Helper()
+
+	for seq := int32(1); seq <= n; seq++ {
+		if err := b.Publish(context.Background(), &Message{Channel: "general", Seq: seq}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// checkMissed checks the received events report every dropped event
+func checkMissed(t *testing.T, b *MemoryBroker, received []*Message) {
+	t.Helper()
+
+	var missed int32
+	for _, msg := range received {
+		missed += msg.Missed
+	}
+	if missed != int32(b.Dropped()) {
+		t.Fatalf("%d missed events reported, want %d", missed, b.Dropped())
+	}
+}
+
+func TestMemoryBrokerDropsOldestEvents(t *testing.T) {
+	b := runMemoryBroker(t, WithBackpressure(Backpressure{Policy: DropOldest, QueueSize: 2}))
+	events := subscribe(t, b, "general")
+
+	// nobody reads meanwhile, the queue and the event being delivered are
+	// kept
+	publishSeqs(t, b, 10)
+	waitDropped(t, b, 7)
+
+	received := receiveAll(events)
+	if len(received)+int(b.Dropped()) != 10 {
+		t.Fatalf("received %d events and dropped %d, want 10 in total", len(received), b.Dropped())
+	}
+	// the newest events are kept
+	if n := len(received); received[n-2].Seq != 9 || received[n-1].Seq != 10 {
+		t.Fatalf("received seqs %d and %d last, want 9 and 10", received[n-2].Seq, received[n-1].Seq)
+	}
+	checkMissed(t, b, received)
+}
+
+func TestMemoryBrokerDropsNewestEvents(t *testing.T) {
+	b := runMemoryBroker(t, WithBackpressure(Backpressure{Policy: DropNewest, QueueSize: 2}))
+	events := subscribe(t, b, "general")
+
+	publishSeqs(t, b, 10)
+	waitDropped(t, b, 7)
+
+	received := receiveAll(events)
+	if len(received)+int(b.Dropped()) != 10 {
+		t.Fatalf("received %d events and dropped %d, want 10 in total", len(received), b.Dropped())
+	}
+	// the oldest events are kept
+	for i, msg := range received {
+		if msg.Seq != int32(i+1) {
+			t.Fatalf("received seq %d at %d, want %d", msg.Seq, i, i+1)
+		}
+	}
+
+	// the event delivered after the drops reports them
+	publishSeqs(t, b, 1)
+	received = append(received, receive(t, events))
+	checkMissed(t, b, received)
+}
+
+func TestMemoryBrokerDisconnectsSlowConsumers(t *testing.T) {
+	b := runMemoryBroker(t, WithBackpressure(Backpressure{Policy: DisconnectSlowConsumer, QueueSize: 2}))
+	events := subscribe(t, b, "general")
+	other := subscribe(t, b, "other")
+
+	publishSeqs(t, b, 10)
+	waitDropped(t, b, 1)
+
+	// the queued events are delivered then the events channel is closed
+	var received []*Message
+	for closed := false; !closed; {
+		select {
+		case msg, ok := <-events:
+			if !ok {
+				closed = true
+				continue
+			}
+			received = append(received, msg)
+		case <-time.After(2 * time.Second):
+			t.Fatal("events channel not closed")
+		}
+	}
+	if len(received) == 0 || len(received) > 3 {
+		t.Fatalf("received %d events, want the queue and the event being delivered", len(received))
+	}
+	for i, msg := range received {
+		if msg.Seq != int32(i+1) {
+			t.Fatalf("received seq %d at %d, want %d", msg.Seq, i, i+1)
+		}
+	}
+
+	// other subscribers are unaffected
+	if err := b.Publish(context.Background(), &Message{Channel: "other", Seq: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if got := receive(t, other); got.Seq != 1 {
+		t.Fatalf("received seq %d, want 1", got.Seq)
+	}
+}
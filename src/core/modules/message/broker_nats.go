@@ -0,0 +1,295 @@
+package message
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// NatsBroker delivers events over NATS. Each channel is published on its own
+// subject below the broker subject and each OnMessage subscription maps to a
+// subscription of its channel subject, so NATS does the fan-out between
+// instances.
+//
+// When created with NewJetStreamBroker, events are stored in a stream and
+// subscriptions are JetStream consumers. A subscription for which DurableName
+// returns a name is bound to a durable consumer that survives the
+// subscription, a client reconnecting with the same name on the same channel
+// resumes after the last acknowledged message. Durable consumers no
+// subscription is bound to for InactiveThreshold are deleted by the server.
+type NatsBroker struct {
+	conn    *nats.Conn
+	subject string
+
+	js     nats.JetStreamContext
+	stream string
+
+	// DurableName returns the durable consumer name for the subscription
+	// being created. It is only used in JetStream mode, an empty name creates
+	// an ephemeral consumer. Anyone resolving to the same name binds to the
+	// same consumer, names must be scoped to the caller's identity.
+	DurableName func(ctx context.Context) string
+	// InactiveThreshold is how long durable consumers are kept without a
+	// subscription, 0 keeps them forever
+	InactiveThreshold time.Duration
+	// Backpressure configures the queue of each subscription, like the
+	// subscriber queues of a MemoryBroker
+	Backpressure Backpressure
+
+	dropped atomic.Uint64
+}
+
+// DefaultInactiveThreshold is the InactiveThreshold of NewJetStreamBroker
+const DefaultInactiveThreshold = 24 * time.Hour
+
+// maxNatsNameLength keeps consumer names within the limits of the server, which
+// uses them as file names
+const maxNatsNameLength = 255
+
+var _ Broker = (*NatsBroker)(nil)
+
+func NewNatsBroker(conn *nats.Conn, subject string, opts ...BrokerOption) *NatsBroker {
+	return &NatsBroker{
+		conn:         conn,
+		subject:      subject,
+		Backpressure: backpressureOf(opts),
+	}
+}
+
+// NewJetStreamBroker creates a broker backed by the given stream, the stream is
+// created with the channel subjects if it does not exist yet.
+func NewJetStreamBroker(conn *nats.Conn, stream string, subject string, opts ...BrokerOption) (*NatsBroker, error) {
+	js, err := conn.JetStream()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := js.StreamInfo(stream); errors.Is(err, nats.ErrStreamNotFound) {
+		_, err = js.AddStream(&nats.StreamConfig{Name: stream, Subjects: []string{subject + ".>"}})
+		if err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &NatsBroker{
+		conn:              conn,
+		subject:           subject,
+		js:                js,
+		stream:            stream,
+		InactiveThreshold: DefaultInactiveThreshold,
+		Backpressure:      backpressureOf(opts),
+	}, nil
+}
+
+// backpressureOf returns the backpressure the options configure
+func backpressureOf(opts []BrokerOption) Backpressure {
+	b := MemoryBroker{Backpressure: DefaultBackpressure}
+	for _, opt := range opts {
+		opt(&b)
+	}
+	return b.Backpressure
+}
+
+// Dropped returns the number of events dropped by the backpressure policy
+// since the broker started.
+func (b *NatsBroker) Dropped() uint64 {
+	return b.dropped.Load()
+}
+
+func (b *NatsBroker) Publish(ctx context.Context, msg *Message) error {
+	if err := validNatsToken("channel", msg.Channel); err != nil {
+		return err
+	}
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	if b.js != nil {
+		_, err = b.js.Publish(b.channelSubject(msg.Channel), payload, nats.Context(ctx))
+		return err
+	}
+	return b.conn.Publish(b.channelSubject(msg.Channel), payload)
+}
+
+func (b *NatsBroker) Subscribe(ctx context.Context, s *OnMessageSubscriber) (Subscription, error) {
+	// the channel is a subject token, wildcards would subscribe to other
+	// channels
+	if err := validNatsToken("channel", s.Channel); err != nil {
+		return nil, err
+	}
+	durable := b.durableName(ctx)
+	if durable != "" {
+		// consumers are bound to a subject, a durable name is kept per channel
+		durable += "-" + s.Channel
+		if err := validNatsToken("durable consumer name", durable); err != nil {
+			return nil, err
+		}
+		if len(durable) > maxNatsNameLength {
+			return nil, fmt.Errorf("durable consumer name %q is too long", durable)
+		}
+	}
+
+	q := newSubscriberQueue(s, b.Backpressure.QueueSize)
+	var track func(e *Message, m *nats.Msg)
+	if b.js != nil {
+		// messages are acknowledged once delivered or dropped, the ones still
+		// queued when the subscription ends are redelivered on resume
+		var mu sync.Mutex
+		pending := map[*Message]*nats.Msg{}
+		q.release = func(e *Message) {
+			mu.Lock()
+			m := pending[e]
+			delete(pending, e)
+			mu.Unlock()
+			if m != nil {
+				_ = m.Ack()
+			}
+		}
+		track = func(e *Message, m *nats.Msg) {
+			mu.Lock()
+			pending[e] = m
+			mu.Unlock()
+		}
+	}
+
+	var quitOnce sync.Once
+	quit := func(disconnect bool) {
+		quitOnce.Do(func() {
+			q.disconnect = disconnect
+			close(q.quit)
+		})
+	}
+
+	// handlers of a single subscription are called sequentially, the queue
+	// keeps the order of the subject
+	disconnected := false
+	handler := func(m *nats.Msg) {
+		if disconnected {
+			return
+		}
+
+		var msg Message
+		if err := json.Unmarshal(m.Data, &msg); err != nil {
+			log.Printf("nats broker: dropping invalid payload on %s: %s", m.Subject, err)
+			b.ack(m)
+			return
+		}
+
+		if !s.matches(&msg) {
+			b.ack(m)
+			return
+		}
+
+		if track != nil {
+			track(&msg, m)
+		}
+		dropped := s.Dropped()
+		if !q.push(&msg, b.Backpressure) {
+			disconnected = true
+			quit(true)
+		}
+		b.dropped.Add(s.Dropped() - dropped)
+	}
+
+	subject := b.channelSubject(s.Channel)
+	var sub *nats.Subscription
+	var err error
+	switch {
+	case b.js == nil:
+		sub, err = b.conn.Subscribe(subject, handler)
+	case durable != "":
+		sub, err = b.subscribeDurable(durable, subject, handler)
+	default:
+		sub, err = b.js.Subscribe(subject, handler, nats.DeliverNew(), nats.ManualAck())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		q.deliver(func(string) {})
+		_ = sub.Unsubscribe()
+	}()
+	return natsSubscription{sub: sub, quit: func() { quit(false) }, done: done}, nil
+}
+
+func (b *NatsBroker) durableName(ctx context.Context) string {
+	if b.DurableName == nil {
+		return ""
+	}
+	return b.DurableName(ctx)
+}
+
+func (b *NatsBroker) channelSubject(channel string) string {
+	return b.subject + "." + channel
+}
+
+func (b *NatsBroker) subscribeDurable(name string, subject string, handler nats.MsgHandler) (*nats.Subscription, error) {
+	// the consumer is created here rather than by js.Subscribe, a consumer
+	// created by the client library is deleted when the subscription ends
+	if _, err := b.js.ConsumerInfo(b.stream, name); errors.Is(err, nats.ErrConsumerNotFound) {
+		_, err = b.js.AddConsumer(b.stream, &nats.ConsumerConfig{
+			Durable:           name,
+			DeliverSubject:    nats.NewInbox(),
+			DeliverPolicy:     nats.DeliverNewPolicy,
+			AckPolicy:         nats.AckExplicitPolicy,
+			FilterSubject:     subject,
+			InactiveThreshold: b.InactiveThreshold,
+		})
+		if err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	return b.js.Subscribe(subject, handler, nats.Bind(b.stream, name), nats.ManualAck())
+}
+
+// validNatsToken rejects values that can't be used as a single subject token or
+// consumer name: empty values, whitespace, separators and wildcards
+func validNatsToken(kind string, value string) error {
+	if value == "" || strings.ContainsAny(value, " \t\r\n.*>/\\") {
+		return fmt.Errorf("invalid %s %q", kind, value)
+	}
+	for _, r := range value {
+		if r < 0x20 || r == 0x7f {
+			return fmt.Errorf("invalid %s %q", kind, value)
+		}
+	}
+	return nil
+}
+
+func (b *NatsBroker) ack(m *nats.Msg) {
+	if b.js != nil {
+		_ = m.Ack()
+	}
+}
+
+type natsSubscription struct {
+	sub  *nats.Subscription
+	quit func()
+	done <-chan struct{}
+}
+
+func (s natsSubscription) Cancel() {
+	s.quit()
+	_ = s.sub.Unsubscribe()
+}
+
+func (s natsSubscription) Done() <-chan struct{} {
+	return s.done
+}
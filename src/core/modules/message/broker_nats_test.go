@@ -0,0 +1,243 @@
+package message
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+)
+
+// runNatsServer starts an in-process NATS server, with JetStream enabled when
+// jetstream is set
+func runNatsServer(t *testing.T, jetstream bool) *server.Server {
+	t.Helper()
+
+	s, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      server.RANDOM_PORT,
+		NoLog:     true,
+		NoSigs:    true,
+		JetStream: jetstream,
+		StoreDir:  t.TempDir(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Start()
+	t.Cleanup(s.Shutdown)
+	if !s.ReadyForConnections(5 * time.Second) {
+		t.Fatal("nats server not ready")
+	}
+	return s
+}
+
+func connectNats(t *testing.T, s *server.Server) *nats.Conn {
+	t.Helper()
+
+	conn, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(conn.Close)
+	return conn
+}
+
+// subscribeNats subscribes like subscribeContext and waits for the server to
+// know the subscription, so events published next on other connections are
+// delivered
+func subscribeNats(t *testing.T, b *NatsBroker, ctx context.Context, channel string) (events <-chan *Message, stop func()) {
+	t.Helper()
+
+	events, cancel := subscribeContext(t, b, ctx, channel)
+	flush := func() {
+		if err := b.conn.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	flush()
+	return events, func() {
+		cancel()
+		flush()
+	}
+}
+
+func TestNatsBrokerSharesEventsBetweenInstances(t *testing.T) {
+	s := runNatsServer(t, false)
+	a := NewNatsBroker(connectNats(t, s), "messages")
+	b := NewNatsBroker(connectNats(t, s), "messages")
+
+	onA, _ := subscribeNats(t, a, context.Background(), "general")
+	onB, _ := subscribeNats(t, b, context.Background(), "general")
+	other, _ := subscribeNats(t, b, context.Background(), "other")
+
+	sent := &Message{Id: "4b1a6f0e-3c7e-4f8e-9a51-0c2f7d7b9a10", Channel: "general", Msg: "hello", Seq: 1}
+	if err := a.Publish(context.Background(), sent); err != nil {
+		t.Fatal(err)
+	}
+	for _, events := range []<-chan *Message{onA, onB} {
+		if got := receive(t, events); *got != *sent {
+			t.Fatalf("received %+v, want %+v", got, sent)
+		}
+	}
+	receiveNothing(t, other)
+}
+
+func TestNatsBrokerRejectsWildcardChannels(t *testing.T) {
+	s := runNatsServer(t, false)
+	b := NewNatsBroker(connectNats(t, s), "messages")
+
+	for _, channel := range []string{">", "*", "general.>", "a b", ""} {
+		events := make(chan *Message)
+		if _, err := b.Subscribe(context.Background(), &OnMessageSubscriber{Channel: channel, Events: events}); err == nil {
+			t.Errorf("subscribed to channel %q", channel)
+		}
+		if err := b.Publish(context.Background(), &Message{Channel: channel}); err == nil {
+			t.Errorf("published to channel %q", channel)
+		}
+	}
+}
+
+func TestJetStreamDurableConsumerResumes(t *testing.T) {
+	s := runNatsServer(t, true)
+	b, err := NewJetStreamBroker(connectNats(t, s), "MESSAGES", "messages")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.DurableName = func(ctx context.Context) string { return "alice" }
+	publish := func(id string) {
+		t.Helper()
+		if err := b.Publish(context.Background(), &Message{Id: id, Channel: "general"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	events, disconnect := subscribeNats(t, b, context.Background(), "general")
+	publish("1")
+	if got := receive(t, events); got.Id != "1" {
+		t.Fatalf("received %s, want 1", got.Id)
+	}
+	disconnect()
+
+	// sent while the client is away
+	publish("2")
+	publish("3")
+
+	events, _ = subscribeNats(t, b, context.Background(), "general")
+	for _, id := range []string{"2", "3"} {
+		if got := receive(t, events); got.Id != id {
+			t.Fatalf("received %s, want %s", got.Id, id)
+		}
+	}
+
+	info, err := b.js.ConsumerInfo("MESSAGES", "alice-general")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Config.InactiveThreshold != DefaultInactiveThreshold {
+		t.Fatalf("inactive threshold is %s, want %s", info.Config.InactiveThreshold, DefaultInactiveThreshold)
+	}
+}
+
+func TestJetStreamDurableConsumersAreSeparate(t *testing.T) {
+	s := runNatsServer(t, true)
+	b, err := NewJetStreamBroker(connectNats(t, s), "MESSAGES", "messages")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.DurableName = func(ctx context.Context) string { return ctx.Value(durableKey{}).(string) }
+
+	alice, _ := subscribeNats(t, b, context.WithValue(context.Background(), durableKey{}, "alice"), "general")
+	bob, _ := subscribeNats(t, b, context.WithValue(context.Background(), durableKey{}, "bob"), "general")
+	if err := b.Publish(context.Background(), &Message{Id: "1", Channel: "general"}); err != nil {
+		t.Fatal(err)
+	}
+	// each consumer gets every message, they don't share acknowledgements
+	receive(t, alice)
+	receive(t, bob)
+}
+
+func TestJetStreamRejectsInvalidDurableNames(t *testing.T) {
+	s := runNatsServer(t, true)
+	b, err := NewJetStreamBroker(connectNats(t, s), "MESSAGES", "messages")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"a.b", "a*", "a>", "a b", "../a"} {
+		b.DurableName = func(ctx context.Context) string { return name }
+		events := make(chan *Message)
+		if _, err := b.Subscribe(context.Background(), &OnMessageSubscriber{Channel: "general", Events: events}); err == nil {
+			t.Errorf("subscribed with durable name %q", name)
+		}
+	}
+}
+
+type durableKey struct{}
+
+func TestNatsBrokerAppliesBackpressure(t *testing.T) {
+	s := runNatsServer(t, false)
+	b := NewNatsBroker(connectNats(t, s), "messages", WithBackpressure(Backpressure{Policy: DropNewest, QueueSize: 2}))
+	events, _ := subscribeNats(t, b, context.Background(), "general")
+
+	// nobody reads meanwhile, the queue and the event being delivered are
+	// kept
+	for seq := int32(1); seq <= 10; seq++ {
+		if err := b.Publish(context.Background(), &Message{Channel: "general", Seq: seq}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitDropped(t, b, 7)
+
+	received := receiveAll(events)
+	if len(received)+int(b.Dropped()) != 10 {
+		t.Fatalf("received %d events and dropped %d, want 10 in total", len(received), b.Dropped())
+	}
+	for i, msg := range received {
+		if msg.Seq != int32(i+1) {
+			t.Fatalf("received seq %d at %d, want %d", msg.Seq, i, i+1)
+		}
+	}
+
+	// the events delivered after the drops report them
+	if err := b.Publish(context.Background(), &Message{Channel: "general", Seq: 11}); err != nil {
+		t.Fatal(err)
+	}
+	received = append(received, receive(t, events))
+	var missed int32
+	for _, msg := range received {
+		missed += msg.Missed
+	}
+	if missed != int32(b.Dropped()) {
+		t.Fatalf("%d missed events reported, want %d", missed, b.Dropped())
+	}
+}
+
+func TestJetStreamAcknowledgesDroppedMessages(t *testing.T) {
+	s := runNatsServer(t, true)
+	b, err := NewJetStreamBroker(connectNats(t, s), "MESSAGES", "messages", WithBackpressure(Backpressure{Policy: DropNewest, QueueSize: 1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.DurableName = func(ctx context.Context) string { return "alice" }
+
+	events, disconnect := subscribeNats(t, b, context.Background(), "general")
+	for seq := int32(1); seq <= 10; seq++ {
+		if err := b.Publish(context.Background(), &Message{Channel: "general", Seq: seq}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitDropped(t, b, 8)
+	receiveAll(events)
+	disconnect()
+
+	// dropped messages aren't redelivered out of order on resume
+	events, _ = subscribeNats(t, b, context.Background(), "general")
+	if err := b.Publish(context.Background(), &Message{Channel: "general", Seq: 11}); err != nil {
+		t.Fatal(err)
+	}
+	if got := receive(t, events); got.Seq != 11 {
+		t.Fatalf("received seq %d after resuming, want 11", got.Seq)
+	}
+}
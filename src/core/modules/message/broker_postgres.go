@@ -0,0 +1,180 @@
+package message
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// postgresMaxNotifyPayload is the largest payload sent inline with NOTIFY. The
+// server limit is 8000 bytes, messages above this are stored in the payload
+// table and only their id is notified.
+const postgresMaxNotifyPayload = 7900
+
+// DefaultPayloadTTL is the PayloadTTL of NewPostgresBroker
+const DefaultPayloadTTL = time.Hour
+
+// PostgresBroker shares events between server instances with Postgres
+// LISTEN/NOTIFY. Every instance listens on the same channel and fans received
+// messages out to its local subscribers.
+type PostgresBroker struct {
+	pool    *pgxpool.Pool
+	channel string
+	table   string
+	local   *MemoryBroker
+
+	// PayloadTTL is how long oversized payloads are kept for the instances to
+	// read them, Run deletes older ones. 0 keeps them forever.
+	PayloadTTL time.Duration
+}
+
+type postgresNotification struct {
+	Message *Message `json:"message,omitempty"`
+	// Ref is the id of a message stored in the payload table
+	Ref string `json:"ref,omitempty"`
+}
+
+var _ Broker = (*PostgresBroker)(nil)
+
+// NewPostgresBroker creates a broker notifying on channel, the table holding
+// oversized payloads is created if it does not exist yet.
+func NewPostgresBroker(ctx context.Context, pool *pgxpool.Pool, channel string, table string, opts ...BrokerOption) (*PostgresBroker, error) {
+	b := &PostgresBroker{
+		pool:       pool,
+		channel:    channel,
+		table:      pgx.Identifier{table}.Sanitize(),
+		local:      NewMemoryBroker(opts...),
+		PayloadTTL: DefaultPayloadTTL,
+	}
+
+	_, err := pool.Exec(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		id TEXT PRIMARY KEY,
+		payload TEXT NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`, b.table))
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+func (b *PostgresBroker) Publish(ctx context.Context, msg *Message) error {
+	payload, err := json.Marshal(postgresNotification{Message: msg})
+	if err != nil {
+		return err
+	}
+
+	if len(payload) > postgresMaxNotifyPayload {
+		stored, err := json.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		if _, err := b.pool.Exec(ctx, fmt.Sprintf("INSERT INTO %s (id, payload) VALUES ($1, $2)", b.table), msg.Id, string(stored)); err != nil {
+			return err
+		}
+
+		payload, err = json.Marshal(postgresNotification{Ref: msg.Id})
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = b.pool.Exec(ctx, "SELECT pg_notify($1, $2)", b.channel, string(payload))
+	return err
+}
+
+func (b *PostgresBroker) Subscribe(ctx context.Context, s *OnMessageSubscriber) (Subscription, error) {
+	return b.local.Subscribe(ctx, s)
+}
+
+// Run listens for notifications on a dedicated connection and delivers them to
+// local subscribers until ctx is done, local subscriptions end with it.
+func (b *PostgresBroker) Run(ctx context.Context) error {
+	go b.local.BroadcastMessageEvent()
+	defer b.local.Close()
+
+	// pruning stops with Run when the connection fails
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	conn, err := b.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+
+	if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{b.channel}.Sanitize()); err != nil {
+		return err
+	}
+	if b.PayloadTTL != 0 {
+		go b.prunePayloads(ctx)
+	}
+
+	for {
+		n, err := conn.Conn().WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+
+		msg, err := b.decode(ctx, n.Payload)
+		if err != nil {
+			log.Printf("postgres broker: dropping notification on %s: %s", n.Channel, err)
+			continue
+		}
+		_ = b.local.Publish(ctx, msg)
+	}
+}
+
+// prunePayloads deletes expired payloads every PayloadTTL until ctx is done.
+// Every instance prunes, the payload of a notification is read as it arrives
+// so deleting it after PayloadTTL is safe whichever instance does.
+func (b *PostgresBroker) prunePayloads(ctx context.Context) {
+	ticker := time.NewTicker(b.PayloadTTL)
+	defer ticker.Stop()
+
+	for {
+		if err := b.prune(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("postgres broker: unable to prune %s: %s", b.table, err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// prune deletes the payloads older than PayloadTTL
+func (b *PostgresBroker) prune(ctx context.Context) error {
+	_, err := b.pool.Exec(ctx, fmt.Sprintf("DELETE FROM %s WHERE created_at < now() - make_interval(secs => $1)", b.table), b.PayloadTTL.Seconds())
+	return err
+}
+
+func (b *PostgresBroker) decode(ctx context.Context, payload string) (*Message, error) {
+	var n postgresNotification
+	if err := json.Unmarshal([]byte(payload), &n); err != nil {
+		return nil, err
+	}
+
+	if n.Message != nil {
+		return n.Message, nil
+	}
+
+	var stored string
+	err := b.pool.QueryRow(ctx, fmt.Sprintf("SELECT payload FROM %s WHERE id = $1", b.table), n.Ref).Scan(&stored)
+	if err != nil {
+		return nil, err
+	}
+
+	var msg Message
+	if err := json.Unmarshal([]byte(stored), &msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
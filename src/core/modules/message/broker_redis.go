@@ -0,0 +1,77 @@
+package message
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// RedisBroker shares events between server instances over Redis
+// PUBLISH/SUBSCRIBE. Every instance subscribes to the same channel and fans
+// received messages out to its local subscribers, including the messages it
+// published itself, so delivery is identical for local and remote senders.
+type RedisBroker struct {
+	client  *redis.Client
+	channel string
+	local   *MemoryBroker
+}
+
+var _ Broker = (*RedisBroker)(nil)
+
+func NewRedisBroker(client *redis.Client, channel string, opts ...BrokerOption) *RedisBroker {
+	return &RedisBroker{
+		client:  client,
+		channel: channel,
+		local:   NewMemoryBroker(opts...),
+	}
+}
+
+func (b *RedisBroker) Publish(ctx context.Context, msg *Message) error {
+	// the message is published as is, the id generated by the sending instance
+	// is what every other instance delivers
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return b.client.Publish(ctx, b.channel, payload).Err()
+}
+
+func (b *RedisBroker) Subscribe(ctx context.Context, s *OnMessageSubscriber) (Subscription, error) {
+	return b.local.Subscribe(ctx, s)
+}
+
+// Run receives messages from Redis and delivers them to local subscribers
+// until ctx is done, local subscriptions end with it.
+func (b *RedisBroker) Run(ctx context.Context) error {
+	go b.local.BroadcastMessageEvent()
+	defer b.local.Close()
+
+	pubsub := b.client.Subscribe(ctx, b.channel)
+	defer pubsub.Close()
+
+	// wait for the subscription to be confirmed, so a failing server is
+	// reported to the caller instead of silently receiving nothing
+	if _, err := pubsub.Receive(ctx); err != nil {
+		return err
+	}
+
+	ch := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case m, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			var msg Message
+			if err := json.Unmarshal([]byte(m.Payload), &msg); err != nil {
+				log.Printf("redis broker: dropping invalid payload on %s: %s", m.Channel, err)
+				continue
+			}
+			_ = b.local.Publish(ctx, &msg)
+		}
+	}
+}
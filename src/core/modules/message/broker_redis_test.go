@@ -0,0 +1,94 @@
+package message
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// runRedisBroker starts a broker on the server, as one more server instance
+func runRedisBroker(t *testing.T, server *miniredis.Miniredis) *RedisBroker {
+	t.Helper()
+
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+	b := NewRedisBroker(client, "messages")
+
+	subscribers := server.PubSubNumSub("messages")["messages"]
+	runBroker(t, b, func() bool {
+		return server.PubSubNumSub("messages")["messages"] > subscribers
+	})
+	return b
+}
+
+func TestRedisBrokerSharesEventsBetweenInstances(t *testing.T) {
+	server := miniredis.RunT(t)
+	a := runRedisBroker(t, server)
+	b := runRedisBroker(t, server)
+
+	onA := subscribe(t, a, "general")
+	onB := subscribe(t, b, "general")
+	other := subscribe(t, b, "other")
+
+	sent := &Message{Id: "4b1a6f0e-3c7e-4f8e-9a51-0c2f7d7b9a10", Channel: "general", Author: "alice", Msg: "hello", Seq: 1}
+	if err := a.Publish(context.Background(), sent); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, events := range []<-chan *Message{onA, onB} {
+		got := receive(t, events)
+		// the id of the sending instance is kept so clients can de-duplicate
+		if *got != *sent {
+			t.Fatalf("received %+v, want %+v", got, sent)
+		}
+	}
+	receiveNothing(t, other)
+}
+
+func TestRedisBrokerKeepsOrder(t *testing.T) {
+	server := miniredis.RunT(t)
+	a := runRedisBroker(t, server)
+	b := runRedisBroker(t, server)
+	events := subscribe(t, b, "general")
+
+	for seq := int32(1); seq <= 20; seq++ {
+		if err := a.Publish(context.Background(), &Message{Id: string(rune('a' + seq)), Channel: "general", Seq: seq}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for seq := int32(1); seq <= 20; seq++ {
+		if got := receive(t, events); got.Seq != seq {
+			t.Fatalf("received seq %d, want %d", got.Seq, seq)
+		}
+	}
+}
+
+func TestRedisBrokerRunEndsSubscriptions(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+	b := NewRedisBroker(client, "messages")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- b.Run(ctx) }()
+	events := subscribe(t, b, "general")
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Run did not return")
+	}
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Fatal("unexpected event")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("events channel not closed")
+	}
+}
@@ -0,0 +1,117 @@
+package message
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// subscribe registers a subscriber of channel on b, events are received on the
+// returned channel until the test ends
+func subscribe(t *testing.T, b Broker, channel string) <-chan *Message {
+	t.Helper()
+
+	events, _ := subscribeContext(t, b, context.Background(), channel)
+	return events
+}
+
+// subscribeContext subscribes like subscribe, the subscription also ends with
+// ctx or when stop is called
+func subscribeContext(t *testing.T, b Broker, ctx context.Context, channel string) (events <-chan *Message, stop func()) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(ctx)
+	ch := make(chan *Message)
+	sub, err := b.Subscribe(ctx, &OnMessageSubscriber{Channel: channel, Events: ch, Stop: ctx.Done()})
+	if err != nil {
+		cancel()
+		t.Fatalf("subscribe: %s", err)
+	}
+	stop = func() {
+		sub.Cancel()
+		cancel()
+	}
+	t.Cleanup(stop)
+	return ch, stop
+}
+
+// runBroker runs a broker backed by a server, as one more server instance,
+// until the test ends. It returns once listening reports that Run receives
+// from the server, so nothing published next is missed.
+func runBroker(t *testing.T, b interface {
+	Run(ctx context.Context) error
+}, listening func() bool) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = b.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !listening() {
+		if time.Now().After(deadline) {
+			t.Fatal("broker did not listen")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// receive waits for the next event
+func receive(t *testing.T, events <-chan *Message) *Message {
+	t.Helper()
+
+	select {
+	case msg, ok := <-events:
+		if !ok {
+			t.Fatal("events channel closed")
+		}
+		return msg
+	case <-time.After(2 * time.Second):
+		t.Fatal("no event received")
+		return nil
+	}
+}
+
+// receiveNothing fails when an event arrives within a short delay
+func receiveNothing(t *testing.T, events <-chan *Message) {
+	t.Helper()
+
+	select {
+	case msg := <-events:
+		t.Fatalf("unexpected event %+v", msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// waitDropped waits for the broker to drop n events
+func waitDropped(t *testing.T, b interface{ Dropped() uint64 }, n uint64) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for b.Dropped() < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d events dropped, want %d", b.Dropped(), n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// receiveAll reads the events until none arrives for a short delay
+func receiveAll(events <-chan *Message) []*Message {
+	var received []*Message
+	for {
+		select {
+		case msg := <-events:
+			received = append(received, msg)
+		case <-time.After(100 * time.Millisecond):
+			return received
+		}
+	}
+}
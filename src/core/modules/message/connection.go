@@ -0,0 +1,39 @@
+package message
+
+// MessageConnection is a page of the message history following the Relay
+// connection spec, the cursor of an edge is the id of its message.
+type MessageConnection struct {
+	Edges    []*MessageEdge
+	PageInfo PageInfo
+}
+
+type MessageEdge struct {
+	Cursor string
+	Node   *Message
+}
+
+type PageInfo struct {
+	HasNextPage     bool
+	HasPreviousPage bool
+	StartCursor     *string
+	EndCursor       *string
+}
+
+func newMessageConnection(messages []*Message, hasPrevious bool, hasNext bool) *MessageConnection {
+	conn := &MessageConnection{
+		Edges: make([]*MessageEdge, len(messages)),
+		PageInfo: PageInfo{
+			HasNextPage:     hasNext,
+			HasPreviousPage: hasPrevious,
+		},
+	}
+	for i, msg := range messages {
+		conn.Edges[i] = &MessageEdge{Cursor: msg.Id, Node: msg}
+	}
+
+	if len(conn.Edges) > 0 {
+		conn.PageInfo.StartCursor = &conn.Edges[0].Cursor
+		conn.PageInfo.EndCursor = &conn.Edges[len(conn.Edges)-1].Cursor
+	}
+	return conn
+}
@@ -0,0 +1,206 @@
+package message
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+)
+
+const (
+	// maxFilterNodes caps the number of conditions of a filter, all nested
+	// and/or/not filters included
+	maxFilterNodes = 32
+	// maxFilterDepth caps the nesting of and/or/not filters
+	maxFilterDepth = 8
+	// maxRegexLength and maxRegexInstructions cap the size of a regex pattern
+	// and of the program it compiles to
+	maxRegexLength       = 256
+	maxRegexInstructions = 1000
+)
+
+// MessageFilterInput is the MessageFilter input of the schema. Every condition
+// set on a filter must hold for a message to match, an empty filter matches
+// every message.
+type MessageFilterInput struct {
+	Field           string                 `json:"field"`
+	Contains        *string                `json:"contains,omitempty"`
+	Prefix          *string                `json:"prefix,omitempty"`
+	Suffix          *string                `json:"suffix,omitempty"`
+	Equals          *string                `json:"equals,omitempty"`
+	Regex           *string                `json:"regex,omitempty"`
+	CaseInsensitive bool                   `json:"caseInsensitive,omitempty"`
+	And             *[]*MessageFilterInput `json:"and,omitempty"`
+	Or              *[]*MessageFilterInput `json:"or,omitempty"`
+	Not             *MessageFilterInput    `json:"not,omitempty"`
+}
+
+// Filter is a compiled MessageFilterInput. A nil Filter matches every message.
+type Filter struct {
+	field func(*Message) string
+	// conditions are matched against the normalized field value
+	conditions []func(string) bool
+	normalize  func(string) string
+
+	and []*Filter
+	or  []*Filter
+	not *Filter
+}
+
+// CompileFilter validates the input and compiles it into a Filter, a nil input
+// compiles to a nil Filter.
+func CompileFilter(in *MessageFilterInput) (*Filter, error) {
+	if in == nil {
+		return nil, nil
+	}
+
+	nodes := 0
+	return compileFilter(in, 1, &nodes)
+}
+
+func compileFilter(in *MessageFilterInput, depth int, nodes *int) (*Filter, error) {
+	if depth > maxFilterDepth {
+		return nil, fmt.Errorf("filter nesting exceeds %d levels", maxFilterDepth)
+	}
+	if *nodes++; *nodes > maxFilterNodes {
+		return nil, fmt.Errorf("filter exceeds %d conditions", maxFilterNodes)
+	}
+
+	f := &Filter{normalize: func(s string) string { return s }}
+	if in.CaseInsensitive {
+		f.normalize = strings.ToLower
+	}
+
+	switch in.Field {
+	case "", "MSG":
+		f.field = func(m *Message) string { return m.Msg }
+	case "AUTHOR":
+		f.field = func(m *Message) string { return m.Author }
+	case "CHANNEL":
+		f.field = func(m *Message) string { return m.Channel }
+	default:
+		return nil, fmt.Errorf("unknown filter field %s", in.Field)
+	}
+
+	if in.Contains != nil {
+		contains := f.normalize(*in.Contains)
+		f.conditions = append(f.conditions, func(s string) bool { return strings.Contains(s, contains) })
+	}
+	if in.Prefix != nil {
+		prefix := f.normalize(*in.Prefix)
+		f.conditions = append(f.conditions, func(s string) bool { return strings.HasPrefix(s, prefix) })
+	}
+	if in.Suffix != nil {
+		suffix := f.normalize(*in.Suffix)
+		f.conditions = append(f.conditions, func(s string) bool { return strings.HasSuffix(s, suffix) })
+	}
+	if in.Equals != nil {
+		equals := f.normalize(*in.Equals)
+		f.conditions = append(f.conditions, func(s string) bool { return s == equals })
+	}
+	if in.Regex != nil {
+		re, err := compileRegex(*in.Regex, in.CaseInsensitive)
+		if err != nil {
+			return nil, err
+		}
+		// the regex folds case itself, matching it against the lowered value
+		// makes no difference
+		f.conditions = append(f.conditions, re.MatchString)
+	}
+
+	if in.And != nil {
+		for _, sub := range *in.And {
+			c, err := compileFilter(sub, depth+1, nodes)
+			if err != nil {
+				return nil, err
+			}
+			f.and = append(f.and, c)
+		}
+	}
+	if in.Or != nil {
+		if len(*in.Or) == 0 {
+			return nil, errors.New("filter or must not be empty")
+		}
+		for _, sub := range *in.Or {
+			c, err := compileFilter(sub, depth+1, nodes)
+			if err != nil {
+				return nil, err
+			}
+			f.or = append(f.or, c)
+		}
+	}
+	if in.Not != nil {
+		c, err := compileFilter(in.Not, depth+1, nodes)
+		if err != nil {
+			return nil, err
+		}
+		f.not = c
+	}
+
+	return f, nil
+}
+
+func compileRegex(pattern string, caseInsensitive bool) (*regexp.Regexp, error) {
+	if len(pattern) > maxRegexLength {
+		return nil, fmt.Errorf("filter regex exceeds %d characters", maxRegexLength)
+	}
+
+	flags := syntax.Perl
+	if caseInsensitive {
+		flags |= syntax.FoldCase
+	}
+	parsed, err := syntax.Parse(pattern, flags)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter regex: %w", err)
+	}
+	prog, err := syntax.Compile(parsed.Simplify())
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter regex: %w", err)
+	}
+	if len(prog.Inst) > maxRegexInstructions {
+		return nil, errors.New("filter regex is too complex")
+	}
+
+	if caseInsensitive {
+		pattern = "(?i)" + pattern
+	}
+	return regexp.Compile(pattern)
+}
+
+// Match reports whether msg passes the filter.
+func (f *Filter) Match(msg *Message) bool {
+	if f == nil {
+		return true
+	}
+
+	value := f.normalize(f.field(msg))
+	for _, condition := range f.conditions {
+		if !condition(value) {
+			return false
+		}
+	}
+
+	for _, sub := range f.and {
+		if !sub.Match(msg) {
+			return false
+		}
+	}
+	if len(f.or) > 0 {
+		matched := false
+		for _, sub := range f.or {
+			if sub.Match(msg) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if f.not != nil && f.not.Match(msg) {
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,86 @@
+package message
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFilterMatches(t *testing.T) {
+	s := func(s string) *string { return &s }
+	list := func(filters ...*MessageFilterInput) *[]*MessageFilterInput { return &filters }
+	msg := &Message{Channel: "general", Author: "alice", Msg: "Hello world"}
+
+	for _, tt := range []struct {
+		name   string
+		filter *MessageFilterInput
+		want   bool
+	}{
+		{"nil", nil, true},
+		{"empty", &MessageFilterInput{}, true},
+		{"contains", &MessageFilterInput{Contains: s("lo wo")}, true},
+		{"prefix", &MessageFilterInput{Prefix: s("Hello")}, true},
+		{"prefix case", &MessageFilterInput{Prefix: s("hello")}, false},
+		{"prefix case insensitive", &MessageFilterInput{Prefix: s("hELLO"), CaseInsensitive: true}, true},
+		{"suffix", &MessageFilterInput{Suffix: s("world")}, true},
+		{"equals", &MessageFilterInput{Equals: s("Hello")}, false},
+		{"regex", &MessageFilterInput{Regex: s("^H.*d$")}, true},
+		{"regex case insensitive", &MessageFilterInput{Regex: s("^hello"), CaseInsensitive: true}, true},
+		{"author", &MessageFilterInput{Field: "AUTHOR", Equals: s("alice")}, true},
+		{"channel", &MessageFilterInput{Field: "CHANNEL", Equals: s("other")}, false},
+		{"every condition", &MessageFilterInput{Prefix: s("Hello"), Suffix: s("there")}, false},
+		{"and", &MessageFilterInput{And: list(&MessageFilterInput{Prefix: s("Hello")}, &MessageFilterInput{Field: "AUTHOR", Equals: s("alice")})}, true},
+		{"and failing", &MessageFilterInput{And: list(&MessageFilterInput{Prefix: s("Hello")}, &MessageFilterInput{Field: "AUTHOR", Equals: s("bob")})}, false},
+		{"or", &MessageFilterInput{Or: list(&MessageFilterInput{Equals: s("bye")}, &MessageFilterInput{Suffix: s("world")})}, true},
+		{"or failing", &MessageFilterInput{Or: list(&MessageFilterInput{Equals: s("bye")}, &MessageFilterInput{Suffix: s("there")})}, false},
+		{"not", &MessageFilterInput{Not: &MessageFilterInput{Contains: s("world")}}, false},
+		{"not not", &MessageFilterInput{Not: &MessageFilterInput{Not: &MessageFilterInput{Contains: s("world")}}}, true},
+		{"conditions and not", &MessageFilterInput{Prefix: s("Hello"), Not: &MessageFilterInput{Field: "AUTHOR", Equals: s("bob")}}, true},
+	} {
+		f, err := CompileFilter(tt.filter)
+		if err != nil {
+			t.Fatalf("%s: %s", tt.name, err)
+		}
+		if got := f.Match(msg); got != tt.want {
+			t.Errorf("%s: matched %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestInvalidFiltersAreRejected(t *testing.T) {
+	s := func(s string) *string { return &s }
+
+	nested := &MessageFilterInput{}
+	for i := 0; i < maxFilterDepth; i++ {
+		nested = &MessageFilterInput{Not: nested}
+	}
+	var many []*MessageFilterInput
+	for i := 0; i < maxFilterNodes; i++ {
+		many = append(many, &MessageFilterInput{Contains: s("a")})
+	}
+
+	for _, tt := range []struct {
+		name   string
+		filter *MessageFilterInput
+	}{
+		{"unknown field", &MessageFilterInput{Field: "ID", Equals: s("a")}},
+		{"empty or", &MessageFilterInput{Or: &[]*MessageFilterInput{}}},
+		{"invalid regex", &MessageFilterInput{Regex: s("((")}},
+		{"long regex", &MessageFilterInput{Regex: s(strings.Repeat("a", maxRegexLength+1))}},
+		{"complex regex", &MessageFilterInput{Regex: s("a{1000}b{1000}")}},
+		{"invalid nested regex", &MessageFilterInput{And: &[]*MessageFilterInput{{Regex: s("[")}}}},
+		{"too deep", nested},
+		{"too many conditions", &MessageFilterInput{And: &many}},
+	} {
+		if _, err := CompileFilter(tt.filter); err == nil {
+			t.Errorf("%s: compiled", tt.name)
+		}
+	}
+
+	// the limits themselves are accepted
+	if _, err := CompileFilter(&MessageFilterInput{Regex: s(strings.Repeat("a", maxRegexLength))}); err != nil {
+		t.Errorf("regex of %d characters: %s", maxRegexLength, err)
+	}
+	if _, err := CompileFilter(&MessageFilterInput{And: &[]*MessageFilterInput{{}}}); err != nil {
+		t.Error(err)
+	}
+}
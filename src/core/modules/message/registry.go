@@ -0,0 +1,63 @@
+package message
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// SubscriptionInfo describes a running onMessage subscription
+type SubscriptionInfo struct {
+	Id           string    `json:"id"`
+	ConnectionId string    `json:"connectionId"`
+	OperationId  string    `json:"operationId"`
+	Channel      string    `json:"channel"`
+	Filter       string    `json:"filter"`
+	StartedAt    time.Time `json:"startedAt"`
+	Delivered    uint64    `json:"delivered"`
+	Dropped      uint64    `json:"dropped"`
+}
+
+// SubscriptionRegistry tracks the running onMessage subscriptions of a
+// resolver.
+type SubscriptionRegistry struct {
+	mu      sync.Mutex
+	entries map[string]*registryEntry
+}
+
+type registryEntry struct {
+	info SubscriptionInfo
+	s    *OnMessageSubscriber
+}
+
+func NewSubscriptionRegistry() *SubscriptionRegistry {
+	return &SubscriptionRegistry{entries: map[string]*registryEntry{}}
+}
+
+func (r *SubscriptionRegistry) add(info SubscriptionInfo, s *OnMessageSubscriber) {
+	r.mu.Lock()
+	r.entries[info.Id] = &registryEntry{info: info, s: s}
+	r.mu.Unlock()
+}
+
+func (r *SubscriptionRegistry) remove(id string) {
+	r.mu.Lock()
+	delete(r.entries, id)
+	r.mu.Unlock()
+}
+
+// List returns the running subscriptions, oldest first
+func (r *SubscriptionRegistry) List() []SubscriptionInfo {
+	r.mu.Lock()
+	infos := make([]SubscriptionInfo, 0, len(r.entries))
+	for _, e := range r.entries {
+		info := e.info
+		info.Delivered = e.s.Delivered()
+		info.Dropped = e.s.Dropped()
+		infos = append(infos, info)
+	}
+	r.mu.Unlock()
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].StartedAt.Before(infos[j].StartedAt) })
+	return infos
+}
@@ -2,83 +2,432 @@ package message
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
-	"strings"
+	"sample-subscription/src/auth"
+	"sample-subscription/src/subscription/transport"
 	"time"
 
 	"github.com/google/uuid"
+	graphql "github.com/graph-gophers/graphql-go"
 )
 
 type MessageResolver struct {
-	MessageEvents       chan *Message
-	HelloSaidSubscriber chan *OnMessageSubscriber
+	Broker   Broker
+	Store    MessageStore
+	Registry *SubscriptionRegistry
+	// LocalStore is set when Store only holds what this server instance wrote
+	// while Broker shares events with other instances. Channels created on
+	// other instances are unknown to the store, they aren't validated and
+	// channels only lists the local ones.
+	LocalStore bool
+
+	sends *sequencer
+}
+
+func NewMessageResolver(broker Broker, store MessageStore) MessageResolver {
+	return MessageResolver{
+		Broker:   broker,
+		Store:    store,
+		Registry: NewSubscriptionRegistry(),
+		sends:    newSequencer(),
+	}
 }
 
 func (MessageResolver) Hello() string {
 	return "Hello"
 }
 
-func (r MessageResolver) OnMessage(ctx context.Context, input struct{ Filter *string }) <-chan *Message {
-	c := make(chan *Message)
-	filter := ""
-	if input.Filter != nil {
-		filter = *input.Filter
+type MessagesArgs struct {
+	Channel graphql.ID
+	First   *int32
+	After   *string
+	Last    *int32
+	Before  *string
+	Filter  *MessageFilterInput
+}
+
+// Messages pages through the stored history, see
+// https://relay.dev/graphql/connections.htm#sec-Pagination-algorithm
+func (r MessageResolver) Messages(ctx context.Context, args MessagesArgs) (*MessageConnection, error) {
+	if (args.First != nil && *args.First < 0) || (args.Last != nil && *args.Last < 0) {
+		return nil, errors.New("first and last must not be negative")
+	}
+	if _, err := r.channel(ctx, args.Channel); err != nil {
+		return nil, err
 	}
-	// NOTE: this could take a while
-	r.HelloSaidSubscriber <- &OnMessageSubscriber{Events: c, Stop: ctx.Done(), Filter: filter}
+	filter, err := CompileFilter(args.Filter)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := ListOptions{
+		Channel: string(args.Channel),
+		After:   deref(args.After),
+		Before:  deref(args.Before),
+		Filter:  filter,
+	}
+	// hasPreviousPage and hasNextPage are only computed in the direction of
+	// the pagination, the other one reports whether a cursor bounds the page
+	hasPrevious, hasNext := opts.After != "", opts.Before != ""
 
-	return c
+	// one more message than requested is listed to know if there is a next
+	// (or previous) page
+	switch {
+	case args.First != nil:
+		opts.Limit = int(*args.First) + 1
+	case args.Last != nil:
+		opts.Limit = int(*args.Last) + 1
+		opts.FromEnd = true
+	}
+
+	messages, err := r.Store.List(ctx, opts)
+	if errors.Is(err, ErrMessageNotFound) {
+		return nil, errors.New("invalid cursor")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if args.First != nil && len(messages) > int(*args.First) {
+		messages = messages[:*args.First]
+		hasNext = true
+	}
+	if args.Last != nil && len(messages) > int(*args.Last) {
+		messages = messages[len(messages)-int(*args.Last):]
+		hasPrevious = true
+	}
+
+	return newMessageConnection(messages, hasPrevious, hasNext), nil
 }
-func (r MessageResolver) SendMessage(ctx context.Context, input struct{ Msg string }) Message {
-	msg := Message{
-		Id:  uuid.New().String(),
-		Msg: input.Msg,
+
+type OnMessageArgs struct {
+	Channel graphql.ID
+	Filter  *MessageFilterInput
+	// Since is the id of the last message seen by the client, stored messages
+	// sent after it are replayed before live events. An evicted since replays
+	// the whole stored history.
+	Since *string
+}
+
+func (r MessageResolver) OnMessage(ctx context.Context, args OnMessageArgs) (<-chan *Message, error) {
+	if _, err := r.channel(ctx, args.Channel); err != nil {
+		return nil, err
+	}
+	// an invalid filter is reported now rather than matching nothing
+	filter, err := CompileFilter(args.Filter)
+	if err != nil {
+		return nil, err
 	}
 
-	log.Println("Send Msg: ", msg)
+	c := make(chan *Message)
+	since := deref(args.Since)
+
+	events := c
+	if since != "" {
+		// live events are received before the history is read so nothing sent
+		// in between is missed, replay forwards them once the history is sent
+		events = make(chan *Message)
+	}
+
+	subscriber := &OnMessageSubscriber{Id: uuid.NewString(), Channel: string(args.Channel), Events: events, Stop: ctx.Done(), Filter: filter}
+	sub, err := r.Broker.Subscribe(ctx, subscriber)
+	if err != nil {
+		return nil, err
+	}
+
+	if since != "" {
+		after, history, gap, err := r.history(ctx, string(args.Channel), since, filter)
+		if err != nil {
+			sub.Cancel()
+			return nil, err
+		}
+		// seqs of other instances don't compare with the local ones
+		if r.LocalStore {
+			after = 0
+		}
+		go replay(ctx, c, after, history, gap, events)
+	}
+
+	if r.Registry != nil {
+		r.Registry.add(subscriptionInfo(ctx, subscriber, args.Filter), subscriber)
+	}
+	// the subscription ends with the operation or with the broker
 	go func() {
 		select {
-		case r.MessageEvents <- &msg:
-		case <-time.After(1 * time.Second):
+		case <-ctx.Done():
+		case <-sub.Done():
+		}
+		sub.Cancel()
+		if r.Registry != nil {
+			r.Registry.remove(subscriber.Id)
 		}
 	}()
-	return msg
+
+	return c, nil
 }
 
-func (r *MessageResolver) BroadcastMessageEvent() {
-	subscribers := map[string]*OnMessageSubscriber{}
-	unsubscribe := make(chan string)
+// history returns the seq of the since message and the stored messages of
+// channel sent after it. since must be a message of channel. When the store no
+// longer has since, such as a message evicted from a MemoryStore, the whole
+// stored history of channel is returned and gap is set.
+func (r MessageResolver) history(ctx context.Context, channel string, since string, filter *Filter) (int32, []*Message, bool, error) {
+	last, err := r.Store.Get(ctx, since)
+	if errors.Is(err, ErrMessageNotFound) {
+		history, err := r.Store.List(ctx, ListOptions{Channel: channel, Filter: filter})
+		return 0, history, true, err
+	}
+	if err != nil {
+		return 0, nil, false, err
+	}
+	if last.Channel != channel {
+		return 0, nil, false, errors.New("invalid since cursor")
+	}
 
-	// NOTE: subscribing and sending events are at odds.
+	history, err := r.Store.List(ctx, ListOptions{Channel: channel, After: since, Filter: filter})
+	if errors.Is(err, ErrMessageNotFound) {
+		// evicted meanwhile
+		history, err = r.Store.List(ctx, ListOptions{Channel: channel, Filter: filter})
+		return 0, history, true, err
+	}
+	if err != nil {
+		return 0, nil, false, err
+	}
+	return last.Seq, history, false, nil
+}
+
+// replay sends history to out then switches to live events. Live events
+// received meanwhile are queued, and the ones the client already has are
+// skipped: those of the history and, unless since is 0, those up to the since
+// seq. When gap is set the first message sent has missed incremented, the
+// messages between since and the history are lost. out is closed once live is
+// closed by the broker and everything pending is sent.
+func replay(ctx context.Context, out chan<- *Message, since int32, history []*Message, gap bool, live <-chan *Message) {
+	replayed := make(map[string]bool, len(history))
+	for _, msg := range history {
+		replayed[msg.Id] = true
+	}
+
+	pending := history
 	for {
+		var send chan<- *Message
+		var next *Message
+		if len(pending) > 0 {
+			send, next = out, pending[0]
+			if gap {
+				copied := *next
+				copied.Missed++
+				next = &copied
+			}
+		} else if live == nil {
+			close(out)
+			return
+		}
+
 		select {
-		case id := <-unsubscribe:
-			delete(subscribers, id)
-		case s := <-r.HelloSaidSubscriber:
-			subscribers[uuid.NewString()] = s
-		case e := <-r.MessageEvents:
-			for id, s := range subscribers {
-				go func(id string, s *OnMessageSubscriber) {
-					select {
-					case <-s.Stop:
-						unsubscribe <- id
-						return
-					default:
-					}
-
-					if s.Filter != "" && !strings.Contains(e.Msg, s.Filter) {
-						// Event does not match filter, skip sending
-						return
-					}
-
-					select {
-					case <-s.Stop:
-						unsubscribe <- id
-					case s.Events <- e:
-					case <-time.After(time.Second):
-					}
-				}(id, s)
+		case <-ctx.Done():
+			return
+		case send <- next:
+			pending = pending[1:]
+			gap = false
+		case e, more := <-live:
+			if !more {
+				live = nil
+				continue
+			}
+			if replayed[e.Id] {
+				// each message is published once
+				delete(replayed, e.Id)
+				continue
+			}
+			if e.Seq > since {
+				pending = append(pending, e)
 			}
 		}
 	}
 }
+
+type SendMessageArgs struct {
+	Channel graphql.ID
+	Msg     string
+}
+
+func (r MessageResolver) SendMessage(ctx context.Context, input SendMessageArgs) (Message, error) {
+	if _, err := r.channel(ctx, input.Channel); err != nil {
+		return Message{}, err
+	}
+
+	msg := Message{
+		Id:      uuid.New().String(),
+		Channel: string(input.Channel),
+		Msg:     input.Msg,
+	}
+	if p := auth.GetPrincipal(ctx); p != nil {
+		msg.Author = p.Subject
+	}
+
+	log.Println("Send Msg: ", msg)
+	if r.sends == nil {
+		if err := r.Store.Append(ctx, &msg); err != nil {
+			return Message{}, err
+		}
+		return msg, r.Broker.Publish(ctx, &msg)
+	}
+
+	turn, err := r.sends.store(msg.Channel, func() error { return r.Store.Append(ctx, &msg) })
+	if err != nil {
+		return Message{}, err
+	}
+	// the message is sent once stored, subscribers that don't get it live
+	// find it in the history, the next published message counts it missed
+	err = turn.publish(ctx, func(skipped int32) error {
+		published := msg
+		published.Skipped = skipped
+		return r.Broker.Publish(ctx, &published)
+	})
+	if err != nil {
+		log.Printf("send message: publishing %s: %s", msg.Id, err)
+	}
+	return msg, nil
+}
+
+// Channels lists the public channels and the private ones the principal is a
+// member of
+func (r MessageResolver) Channels(ctx context.Context) ([]*Channel, error) {
+	channels, err := r.Store.ListChannels(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	visible := channels[:0]
+	for _, channel := range channels {
+		member, err := r.isMember(ctx, channel)
+		if err != nil {
+			return nil, err
+		}
+		if member {
+			visible = append(visible, channel)
+		}
+	}
+	return visible, nil
+}
+
+type CreateChannelArgs struct {
+	Name    string
+	Private *bool
+}
+
+// CreateChannel creates a channel, the principal creating a private channel is
+// its first member.
+func (r MessageResolver) CreateChannel(ctx context.Context, input CreateChannelArgs) (*Channel, error) {
+	channel := &Channel{
+		Id:      uuid.New().String(),
+		Name:    input.Name,
+		Private: input.Private != nil && *input.Private,
+	}
+
+	p := auth.GetPrincipal(ctx)
+	if channel.Private {
+		// other instances would take the channel for a public one they
+		// don't know about
+		if r.LocalStore {
+			return nil, errors.New("private channels require a store shared by every instance")
+		}
+		if p == nil {
+			return nil, errors.New("private channels can only be created by authenticated principals")
+		}
+	}
+
+	if err := r.Store.CreateChannel(ctx, channel); err != nil {
+		return nil, err
+	}
+	if channel.Private {
+		if err := r.Store.AddChannelMember(ctx, channel.Id, p.Subject); err != nil {
+			return nil, err
+		}
+	}
+	return channel, nil
+}
+
+type AddChannelMemberArgs struct {
+	Channel graphql.ID
+	Subject string
+}
+
+// AddChannelMember lets subject access a private channel, only members can add
+// others.
+func (r MessageResolver) AddChannelMember(ctx context.Context, input AddChannelMemberArgs) (bool, error) {
+	channel, err := r.channel(ctx, input.Channel)
+	if err != nil {
+		return false, err
+	}
+	if !channel.Private {
+		return false, fmt.Errorf("channel %s is public", input.Channel)
+	}
+
+	if err := r.Store.AddChannelMember(ctx, channel.Id, input.Subject); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// channel returns the channel id refers to. Private channels the principal
+// isn't a member of are reported as unknown, like missing ones.
+func (r MessageResolver) channel(ctx context.Context, id graphql.ID) (*Channel, error) {
+	channel, err := r.Store.GetChannel(ctx, string(id))
+	if errors.Is(err, ErrChannelNotFound) {
+		if r.LocalStore {
+			return &Channel{Id: string(id)}, nil
+		}
+		return nil, fmt.Errorf("unknown channel %s", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	member, err := r.isMember(ctx, channel)
+	if err != nil {
+		return nil, err
+	}
+	if !member {
+		return nil, fmt.Errorf("unknown channel %s", id)
+	}
+	return channel, nil
+}
+
+// isMember reports whether the principal may access channel, everyone is a
+// member of public channels
+func (r MessageResolver) isMember(ctx context.Context, channel *Channel) (bool, error) {
+	if !channel.Private {
+		return true, nil
+	}
+	p := auth.GetPrincipal(ctx)
+	if p == nil {
+		return false, nil
+	}
+	return r.Store.IsChannelMember(ctx, channel.Id, p.Subject)
+}
+
+func subscriptionInfo(ctx context.Context, s *OnMessageSubscriber, filter *MessageFilterInput) SubscriptionInfo {
+	info := SubscriptionInfo{
+		Id:           s.Id,
+		ConnectionId: transport.GetConnectionID(ctx),
+		OperationId:  transport.GetOperationID(ctx),
+		Channel:      s.Channel,
+		StartedAt:    time.Now(),
+	}
+	if filter != nil {
+		b, _ := json.Marshal(filter)
+		info.Filter = string(b)
+	}
+	return info
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,456 @@
+package message
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sample-subscription/src/auth"
+	"sync"
+	"testing"
+	"time"
+
+	graphql "github.com/graph-gophers/graphql-go"
+)
+
+// runMemoryBroker starts a broker until the test ends
+func runMemoryBroker(t *testing.T, opts ...BrokerOption) *MemoryBroker {
+	t.Helper()
+
+	b := NewMemoryBroker(opts...)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		b.BroadcastMessageEvent()
+	}()
+	t.Cleanup(func() {
+		_ = b.Close()
+		<-done
+	})
+	return b
+}
+
+func newTestResolver(b Broker) MessageResolver {
+	return NewMessageResolver(b, NewMemoryStore(100))
+}
+
+func createChannel(t *testing.T, r MessageResolver, name string) graphql.ID {
+	t.Helper()
+
+	channel, err := r.CreateChannel(context.Background(), CreateChannelArgs{Name: name})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return graphql.ID(channel.Id)
+}
+
+// onMessage subscribes until the test ends
+func onMessage(t *testing.T, r MessageResolver, args OnMessageArgs) <-chan *Message {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	events, err := r.OnMessage(ctx, args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return events
+}
+
+func sendMessage(t *testing.T, r MessageResolver, channel graphql.ID, msg string) Message {
+	t.Helper()
+
+	sent, err := r.SendMessage(context.Background(), SendMessageArgs{Channel: channel, Msg: msg})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sent
+}
+
+func TestUnknownChannelsAreRejected(t *testing.T) {
+	r := newTestResolver(runMemoryBroker(t))
+
+	if _, err := r.OnMessage(context.Background(), OnMessageArgs{Channel: "unknown"}); err == nil {
+		t.Error("subscribed to an unknown channel")
+	}
+	if _, err := r.SendMessage(context.Background(), SendMessageArgs{Channel: "unknown", Msg: "hello"}); err == nil {
+		t.Error("sent to an unknown channel")
+	}
+}
+
+func TestMessagesPagination(t *testing.T) {
+	r := newTestResolver(runMemoryBroker(t))
+	channel := createChannel(t, r, "general")
+	var sent []*Message
+	for i := 0; i < 5; i++ {
+		msg := sendMessage(t, r, channel, fmt.Sprint(i))
+		sent = append(sent, &msg)
+	}
+	other := createChannel(t, r, "other")
+	sendMessage(t, r, other, "other")
+	n := func(n int32) *int32 { return &n }
+
+	for _, tt := range []struct {
+		name                 string
+		args                 MessagesArgs
+		want                 []*Message
+		hasPrevious, hasNext bool
+	}{
+		{"all", MessagesArgs{}, sent, false, false},
+		{"first", MessagesArgs{First: n(2)}, sent[:2], false, true},
+		{"first after", MessagesArgs{First: n(2), After: &sent[1].Id}, sent[2:4], true, true},
+		{"first of the rest", MessagesArgs{First: n(5), After: &sent[2].Id}, sent[3:], true, false},
+		{"last", MessagesArgs{Last: n(2)}, sent[3:], true, false},
+		{"last before", MessagesArgs{Last: n(2), Before: &sent[3].Id}, sent[1:3], true, true},
+		{"last of the rest", MessagesArgs{Last: n(5), Before: &sent[2].Id}, sent[:2], false, true},
+	} {
+		tt.args.Channel = channel
+		conn, err := r.Messages(context.Background(), tt.args)
+		if err != nil {
+			t.Fatalf("%s: %s", tt.name, err)
+		}
+		var got []*Message
+		for _, edge := range conn.Edges {
+			if edge.Cursor != edge.Node.Id {
+				t.Errorf("%s: cursor %s of %s", tt.name, edge.Cursor, edge.Node.Id)
+			}
+			got = append(got, edge.Node)
+		}
+		if ids(got) != ids(tt.want) {
+			t.Errorf("%s: got %s, want %s", tt.name, ids(got), ids(tt.want))
+		}
+		info := conn.PageInfo
+		if info.HasPreviousPage != tt.hasPrevious || info.HasNextPage != tt.hasNext {
+			t.Errorf("%s: hasPreviousPage %t and hasNextPage %t, want %t and %t", tt.name, info.HasPreviousPage, info.HasNextPage, tt.hasPrevious, tt.hasNext)
+		}
+		if *info.StartCursor != tt.want[0].Id || *info.EndCursor != tt.want[len(tt.want)-1].Id {
+			t.Errorf("%s: cursors %s and %s", tt.name, *info.StartCursor, *info.EndCursor)
+		}
+	}
+
+	unknown := "unknown"
+	for _, args := range []MessagesArgs{
+		{First: n(-1)},
+		{Last: n(-1)},
+		{First: n(2), After: &unknown},
+		{Last: n(2), Before: &unknown},
+	} {
+		args.Channel = channel
+		if _, err := r.Messages(context.Background(), args); err == nil {
+			t.Errorf("listed %+v", args)
+		}
+	}
+	if _, err := r.Messages(context.Background(), MessagesArgs{Channel: channel, After: &unknown}); err == nil || err.Error() != "invalid cursor" {
+		t.Errorf("got %v for an unknown cursor", err)
+	}
+}
+
+func TestLocalStoresAcceptChannelsOfOtherInstances(t *testing.T) {
+	// both instances share the broker and keep their own store
+	b := runMemoryBroker(t)
+	a, other := newTestResolver(b), newTestResolver(b)
+	a.LocalStore, other.LocalStore = true, true
+
+	channel := createChannel(t, a, "general")
+	onA := onMessage(t, a, OnMessageArgs{Channel: channel})
+	onOther := onMessage(t, other, OnMessageArgs{Channel: channel})
+
+	sent := sendMessage(t, other, channel, "hello")
+	for _, events := range []<-chan *Message{onA, onOther} {
+		if got := receive(t, events); got.Id != sent.Id {
+			t.Fatalf("received %s, want %s", got.Id, sent.Id)
+		}
+	}
+}
+
+func TestSendsAreDeliveredInSeqOrder(t *testing.T) {
+	r := newTestResolver(runMemoryBroker(t, WithBackpressure(Backpressure{Policy: DropOldest, QueueSize: 4})))
+	channel := createChannel(t, r, "general")
+	events := onMessage(t, r, OnMessageArgs{Channel: channel})
+
+	// nobody reads meanwhile, the subscription drops events
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := r.SendMessage(context.Background(), SendMessageArgs{Channel: channel, Msg: "hello"}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	var last int32
+	for _, msg := range receiveAll(events) {
+		// gaps in seqs are the missed messages
+		if msg.Seq != last+1+msg.Missed {
+			t.Fatalf("seq %d with %d missed after seq %d", msg.Seq, msg.Missed, last)
+		}
+		last = msg.Seq
+	}
+	if last != 50 {
+		t.Fatalf("last seq %d, want 50", last)
+	}
+}
+
+// publishHook calls before with every message published through it, the
+// message isn't published when it returns an error
+type publishHook struct {
+	Broker
+	before func(msg *Message) error
+}
+
+func (b publishHook) Publish(ctx context.Context, msg *Message) error {
+	if err := b.before(msg); err != nil {
+		return err
+	}
+	return b.Broker.Publish(ctx, msg)
+}
+
+func TestFailedPublishesAreCountedMissed(t *testing.T) {
+	r := newTestResolver(publishHook{Broker: runMemoryBroker(t), before: func(msg *Message) error {
+		if msg.Msg == "lost" {
+			return errors.New("publish failed")
+		}
+		return nil
+	}})
+	channel := createChannel(t, r, "general")
+	events := onMessage(t, r, OnMessageArgs{Channel: channel})
+
+	// the message is stored, the mutation succeeds
+	lost := sendMessage(t, r, channel, "lost")
+	if _, err := r.Store.Get(context.Background(), lost.Id); err != nil {
+		t.Fatal(err)
+	}
+	next := sendMessage(t, r, channel, "next")
+	if got := receive(t, events); got.Id != next.Id || got.Missed != 1 {
+		t.Fatalf("received %+v, want %s with 1 missed", got, next.Msg)
+	}
+	after := sendMessage(t, r, channel, "after")
+	if got := receive(t, events); got.Id != after.Id || got.Missed != 0 {
+		t.Fatalf("received %+v, want %s with 0 missed", got, after.Msg)
+	}
+}
+
+func TestSlowPublishesDoNotHoldTheChannel(t *testing.T) {
+	release := make(chan struct{})
+	r := newTestResolver(publishHook{Broker: runMemoryBroker(t), before: func(msg *Message) error {
+		if msg.Msg == "slow" {
+			<-release
+		}
+		return nil
+	}})
+	channel := createChannel(t, r, "general")
+	events := onMessage(t, r, OnMessageArgs{Channel: channel})
+
+	sent := make(chan error, 2)
+	send := func(msg string) {
+		_, err := r.SendMessage(context.Background(), SendMessageArgs{Channel: channel, Msg: msg})
+		sent <- err
+	}
+	waitStored := func(n int) {
+		t.Helper()
+
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			stored, err := r.Store.List(context.Background(), ListOptions{Channel: string(channel)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(stored) == n {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%d messages stored, want %d", len(stored), n)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	go send("slow")
+	waitStored(1)
+	// the next message is stored while the slow one is being published
+	go send("next")
+	waitStored(2)
+	receiveNothing(t, events)
+
+	// and published after it
+	close(release)
+	for _, want := range []string{"slow", "next"} {
+		if got := receive(t, events); got.Msg != want {
+			t.Fatalf("received %s, want %s", got.Msg, want)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-sent; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSinceReplaysMissedMessages(t *testing.T) {
+	r := newTestResolver(runMemoryBroker(t))
+	channel := createChannel(t, r, "general")
+	seen := sendMessage(t, r, channel, "seen")
+	missed := sendMessage(t, r, channel, "missed")
+
+	events := onMessage(t, r, OnMessageArgs{Channel: channel, Since: &seen.Id})
+	if got := receive(t, events); got.Id != missed.Id {
+		t.Fatalf("replayed %s, want %s", got.Msg, missed.Msg)
+	}
+	live := sendMessage(t, r, channel, "live")
+	if got := receive(t, events); got.Id != live.Id {
+		t.Fatalf("received %s, want %s", got.Msg, live.Msg)
+	}
+}
+
+func TestSinceAfterEvictionReplaysWithGap(t *testing.T) {
+	r := NewMessageResolver(runMemoryBroker(t), NewMemoryStore(2))
+	channel := createChannel(t, r, "general")
+	seen := sendMessage(t, r, channel, "seen")
+	sendMessage(t, r, channel, "evicted")
+	retained := []Message{sendMessage(t, r, channel, "first"), sendMessage(t, r, channel, "second")}
+
+	events := onMessage(t, r, OnMessageArgs{Channel: channel, Since: &seen.Id})
+	for i, want := range retained {
+		got := receive(t, events)
+		if got.Id != want.Id {
+			t.Fatalf("replayed %s, want %s", got.Msg, want.Msg)
+		}
+		// only the first message reports the gap
+		if missed := int32(1 - i); got.Missed != missed {
+			t.Errorf("%s: missed %d, want %d", got.Msg, got.Missed, missed)
+		}
+	}
+	live := sendMessage(t, r, channel, "live")
+	if got := receive(t, events); got.Id != live.Id || got.Missed != 0 {
+		t.Fatalf("received %+v, want %s", got, live.Msg)
+	}
+}
+
+func TestSinceCursorOfOtherChannelIsRejected(t *testing.T) {
+	r := newTestResolver(runMemoryBroker(t))
+	general := createChannel(t, r, "general")
+	other := createChannel(t, r, "other")
+	for i := 0; i < 3; i++ {
+		sendMessage(t, r, other, "other")
+	}
+	cursor := sendMessage(t, r, other, "other")
+
+	if _, err := r.OnMessage(context.Background(), OnMessageArgs{Channel: general, Since: &cursor.Id}); err == nil {
+		t.Fatal("accepted the cursor of another channel")
+	}
+	if subs := r.Registry.List(); len(subs) != 0 {
+		t.Fatalf("rejected subscription registered: %+v", subs)
+	}
+}
+
+func TestSinceKeepsMessagesOfOtherInstances(t *testing.T) {
+	b := runMemoryBroker(t)
+	a, other := newTestResolver(b), newTestResolver(b)
+	a.LocalStore, other.LocalStore = true, true
+	channel := createChannel(t, a, "general")
+	var cursor Message
+	for i := 0; i < 3; i++ {
+		cursor = sendMessage(t, a, channel, "seen")
+	}
+
+	events := onMessage(t, a, OnMessageArgs{Channel: channel, Since: &cursor.Id})
+	// the store of other assigns a seq below the cursor's
+	live := sendMessage(t, other, channel, "live")
+	if live.Seq >= cursor.Seq {
+		t.Fatalf("seq %d isn't below the cursor's %d", live.Seq, cursor.Seq)
+	}
+	if got := receive(t, events); got.Id != live.Id {
+		t.Fatalf("received %s, want %s", got.Msg, live.Msg)
+	}
+}
+
+func TestPrivateChannelsAreRestrictedToMembers(t *testing.T) {
+	r := newTestResolver(runMemoryBroker(t))
+	alice := auth.WithPrincipal(context.Background(), &auth.Principal{Subject: "alice"})
+	bob := auth.WithPrincipal(context.Background(), &auth.Principal{Subject: "bob"})
+	private := true
+	channel, err := r.CreateChannel(alice, CreateChannelArgs{Name: "secret", Private: &private})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := graphql.ID(channel.Id)
+
+	for name, ctx := range map[string]context.Context{"anonymous": context.Background(), "bob": bob} {
+		if _, err := r.OnMessage(ctx, OnMessageArgs{Channel: id}); err == nil {
+			t.Errorf("%s subscribed", name)
+		}
+		if _, err := r.SendMessage(ctx, SendMessageArgs{Channel: id, Msg: "hello"}); err == nil {
+			t.Errorf("%s sent a message", name)
+		}
+		if _, err := r.Messages(ctx, MessagesArgs{Channel: id}); err == nil {
+			t.Errorf("%s listed the messages", name)
+		}
+		if _, err := r.AddChannelMember(ctx, AddChannelMemberArgs{Channel: id, Subject: "mallory"}); err == nil {
+			t.Errorf("%s added a member", name)
+		}
+		if channels, _ := r.Channels(ctx); len(channels) != 0 {
+			t.Errorf("%s listed %d channels", name, len(channels))
+		}
+	}
+
+	if _, err := r.AddChannelMember(alice, AddChannelMemberArgs{Channel: id, Subject: "bob"}); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(bob)
+	defer cancel()
+	events, err := r.OnMessage(ctx, OnMessageArgs{Channel: id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sent, err := r.SendMessage(alice, SendMessageArgs{Channel: id, Msg: "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := receive(t, events); got.Id != sent.Id {
+		t.Fatalf("received %s, want %s", got.Id, sent.Id)
+	}
+}
+
+func TestLocalStoresRejectPrivateChannels(t *testing.T) {
+	r := newTestResolver(runMemoryBroker(t))
+	r.LocalStore = true
+	alice := auth.WithPrincipal(context.Background(), &auth.Principal{Subject: "alice"})
+
+	private := true
+	if _, err := r.CreateChannel(alice, CreateChannelArgs{Name: "secret", Private: &private}); err == nil {
+		t.Fatal("created a private channel other instances can't check")
+	}
+}
+
+func TestOnMessageEndsWithTheBroker(t *testing.T) {
+	before := runtime.NumGoroutine()
+	for i := 0; i < 200; i++ {
+		b := NewMemoryBroker()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			b.BroadcastMessageEvent()
+		}()
+		r := newTestResolver(b)
+		channel := createChannel(t, r, "general")
+		// the operation outlives the broker
+		if _, err := r.OnMessage(context.Background(), OnMessageArgs{Channel: channel}); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Close(); err != nil {
+			t.Fatal(err)
+		}
+		<-done
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > before {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<16)
+			t.Fatalf("%d goroutines, %d before\n%s", runtime.NumGoroutine(), before, buf[:runtime.Stack(buf, true)])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
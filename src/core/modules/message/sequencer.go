@@ -0,0 +1,83 @@
+package message
+
+import (
+	"context"
+	"sync"
+)
+
+// sequencer orders the sends of each channel. A message is stored, which
+// assigns its seq, with its channel locked, then published once the message
+// stored before it is, so brokers receive them in seq order. Publishing
+// doesn't hold the channel, the next message is stored meanwhile.
+type sequencer struct {
+	mu       sync.Mutex
+	channels map[string]*channelSends
+}
+
+type channelSends struct {
+	mu sync.Mutex
+	// published is closed once the last stored message is published, or
+	// failed to be
+	published chan struct{}
+	// skipped counts the messages stored but not published since the last
+	// published one, it is only used by the turn being published
+	skipped int32
+}
+
+func newSequencer() *sequencer {
+	return &sequencer{channels: map[string]*channelSends{}}
+}
+
+// turn is the place of a stored message in the publishes of its channel
+type turn struct {
+	c        *channelSends
+	previous <-chan struct{}
+	done     chan struct{}
+}
+
+// store runs append with channel locked and returns the turn of the stored
+// message.
+func (s *sequencer) store(channel string, append func() error) (*turn, error) {
+	s.mu.Lock()
+	c, ok := s.channels[channel]
+	if !ok {
+		c = &channelSends{published: make(chan struct{})}
+		close(c.published)
+		s.channels[channel] = c
+	}
+	s.mu.Unlock()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := append(); err != nil {
+		return nil, err
+	}
+	t := &turn{c: c, previous: c.published, done: make(chan struct{})}
+	c.published = t.done
+	return t, nil
+}
+
+// publish waits for the previous message of the channel to be published then
+// calls fn with the number of messages skipped since the last published one.
+// When ctx is done first, the message is skipped.
+func (t *turn) publish(ctx context.Context, fn func(skipped int32) error) error {
+	select {
+	case <-t.previous:
+	case <-ctx.Done():
+		// the next turn still waits for the previous one
+		go func() {
+			<-t.previous
+			t.c.skipped++
+			close(t.done)
+		}()
+		return ctx.Err()
+	}
+	defer close(t.done)
+
+	if err := fn(t.c.skipped); err != nil {
+		t.c.skipped++
+		return err
+	}
+	t.c.skipped = 0
+	return nil
+}
@@ -0,0 +1,51 @@
+package message
+
+import (
+	"context"
+	"errors"
+)
+
+var (
+	ErrMessageNotFound = errors.New("message not found")
+	ErrChannelNotFound = errors.New("channel not found")
+)
+
+// ChannelStore keeps the channels messages are sent to and the members of the
+// private ones. Members are principal subjects.
+type ChannelStore interface {
+	CreateChannel(ctx context.Context, channel *Channel) error
+	GetChannel(ctx context.Context, id string) (*Channel, error)
+	ListChannels(ctx context.Context) ([]*Channel, error)
+	AddChannelMember(ctx context.Context, channel string, subject string) error
+	IsChannelMember(ctx context.Context, channel string, subject string) (bool, error)
+}
+
+// MessageStore keeps the history of sent messages. Messages are listed in the
+// order they were appended and the id of a message is its cursor.
+type MessageStore interface {
+	ChannelStore
+
+	Append(ctx context.Context, msg *Message) error
+	Get(ctx context.Context, id string) (*Message, error)
+	List(ctx context.Context, opts ListOptions) ([]*Message, error)
+}
+
+// ListOptions selects a window of the message history. After and Before are
+// message ids, ErrMessageNotFound is returned when either is unknown to the
+// store.
+type ListOptions struct {
+	// Channel only lists the messages of the given channel
+	Channel string
+	After   string
+	Before  string
+	// Limit caps the number of messages returned, 0 returns the whole window
+	Limit int
+	// FromEnd keeps the newest messages of the window when it is limited
+	FromEnd bool
+	// Filter only lists matching messages, like the onMessage filter
+	Filter *Filter
+}
+
+func (o ListOptions) matches(msg *Message) bool {
+	return (o.Channel == "" || o.Channel == msg.Channel) && o.Filter.Match(msg)
+}
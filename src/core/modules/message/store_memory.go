@@ -0,0 +1,159 @@
+package message
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// MemoryStore is a MessageStore holding the last messages in a ring buffer,
+// older messages are evicted once the buffer is full.
+type MemoryStore struct {
+	mu sync.RWMutex
+	// buf holds the message at position p in buf[p%len(buf)]
+	buf []*Message
+	// next is the position of the next appended message
+	next  uint64
+	index map[string]uint64
+	// seqs holds the last seq assigned in each channel
+	seqs map[string]int32
+
+	channels []*Channel
+	// members holds the subjects of each channel that has members
+	members map[string]map[string]bool
+}
+
+var _ MessageStore = (*MemoryStore)(nil)
+
+// NewMemoryStore creates a store keeping the last capacity messages, capacity
+// must be at least 1.
+func NewMemoryStore(capacity int) *MemoryStore {
+	if capacity < 1 {
+		panic(fmt.Sprintf("message: invalid memory store capacity %d", capacity))
+	}
+	return &MemoryStore{
+		buf:     make([]*Message, capacity),
+		index:   map[string]uint64{},
+		seqs:    map[string]int32{},
+		members: map[string]map[string]bool{},
+	}
+}
+
+func (s *MemoryStore) Append(ctx context.Context, msg *Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seqs[msg.Channel]++
+	msg.Seq = s.seqs[msg.Channel]
+
+	slot := s.next % uint64(len(s.buf))
+	if evicted := s.buf[slot]; evicted != nil {
+		delete(s.index, evicted.Id)
+	}
+	s.buf[slot] = msg
+	s.index[msg.Id] = s.next
+	s.next++
+	return nil
+}
+
+func (s *MemoryStore) Get(ctx context.Context, id string) (*Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	pos, ok := s.index[id]
+	if !ok {
+		return nil, ErrMessageNotFound
+	}
+	return s.buf[pos%uint64(len(s.buf))], nil
+}
+
+func (s *MemoryStore) List(ctx context.Context, opts ListOptions) ([]*Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// [start, end) is the window of positions to list
+	start, end := s.first(), s.next
+	if opts.After != "" {
+		pos, ok := s.index[opts.After]
+		if !ok {
+			return nil, ErrMessageNotFound
+		}
+		start = pos + 1
+	}
+	if opts.Before != "" {
+		pos, ok := s.index[opts.Before]
+		if !ok {
+			return nil, ErrMessageNotFound
+		}
+		end = pos
+	}
+
+	messages := []*Message{}
+	for pos := start; pos < end; pos++ {
+		if msg := s.buf[pos%uint64(len(s.buf))]; opts.matches(msg) {
+			messages = append(messages, msg)
+		}
+	}
+
+	if opts.Limit > 0 && len(messages) > opts.Limit {
+		if opts.FromEnd {
+			messages = messages[len(messages)-opts.Limit:]
+		} else {
+			messages = messages[:opts.Limit]
+		}
+	}
+	return messages, nil
+}
+
+// first returns the position of the oldest message still in the buffer
+func (s *MemoryStore) first() uint64 {
+	if s.next < uint64(len(s.buf)) {
+		return 0
+	}
+	return s.next - uint64(len(s.buf))
+}
+
+func (s *MemoryStore) CreateChannel(ctx context.Context, channel *Channel) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.channels = append(s.channels, channel)
+	return nil
+}
+
+func (s *MemoryStore) GetChannel(ctx context.Context, id string) (*Channel, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, channel := range s.channels {
+		if channel.Id == id {
+			return channel, nil
+		}
+	}
+	return nil, ErrChannelNotFound
+}
+
+func (s *MemoryStore) ListChannels(ctx context.Context) ([]*Channel, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return append([]*Channel{}, s.channels...), nil
+}
+
+func (s *MemoryStore) AddChannelMember(ctx context.Context, channel string, subject string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.members[channel] == nil {
+		s.members[channel] = map[string]bool{}
+	}
+	s.members[channel][subject] = true
+	return nil
+}
+
+func (s *MemoryStore) IsChannelMember(ctx context.Context, channel string, subject string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.members[channel][subject], nil
+}
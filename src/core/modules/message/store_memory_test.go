@@ -0,0 +1,48 @@
+package message
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestMemoryStoreHistory(t *testing.T) {
+	testMessageHistory(t, NewMemoryStore(10))
+}
+
+func TestMemoryStoreEvictsOldestMessages(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore(3)
+	messages := appendMessages(t, s, "general", 5)
+
+	if _, err := s.Get(ctx, messages[1].Id); !errors.Is(err, ErrMessageNotFound) {
+		t.Errorf("got %v for an evicted message", err)
+	}
+	if _, err := s.List(ctx, ListOptions{After: messages[1].Id}); !errors.Is(err, ErrMessageNotFound) {
+		t.Errorf("listed after an evicted message: %v", err)
+	}
+	listed, err := s.List(ctx, ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids(listed) != ids(messages[2:]) {
+		t.Errorf("listed %s, want %s", ids(listed), ids(messages[2:]))
+	}
+	// seqs keep increasing past the capacity
+	if messages[4].Seq != 5 {
+		t.Errorf("seq %d, want 5", messages[4].Seq)
+	}
+}
+
+func TestMemoryStoreRejectsInvalidCapacity(t *testing.T) {
+	for _, capacity := range []int{0, -1} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("created a store of capacity %d", capacity)
+				}
+			}()
+			NewMemoryStore(capacity)
+		}()
+	}
+}
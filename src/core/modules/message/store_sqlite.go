@@ -0,0 +1,213 @@
+package message
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+
+	_ "modernc.org/sqlite"
+)
+
+// SQLiteStore is a MessageStore persisting the whole history in a SQLite
+// database file.
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+var _ MessageStore = (*SQLiteStore)(nil)
+
+// NewSQLiteStore opens the database at path, the tables are created if they do
+// not exist yet.
+func NewSQLiteStore(ctx context.Context, path string) (*SQLiteStore, error) {
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, err
+	}
+	// sqlite allows a single writer
+	db.SetMaxOpenConns(1)
+
+	_, err = db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS channels (
+		seq INTEGER PRIMARY KEY AUTOINCREMENT,
+		id TEXT NOT NULL UNIQUE,
+		name TEXT NOT NULL,
+		private INTEGER NOT NULL DEFAULT 0
+	);
+	CREATE TABLE IF NOT EXISTS channel_members (
+		channel TEXT NOT NULL,
+		subject TEXT NOT NULL,
+		PRIMARY KEY (channel, subject)
+	);
+	CREATE TABLE IF NOT EXISTS messages (
+		seq INTEGER PRIMARY KEY AUTOINCREMENT,
+		id TEXT NOT NULL UNIQUE,
+		channel TEXT NOT NULL,
+		channel_seq INTEGER NOT NULL,
+		author TEXT NOT NULL DEFAULT '',
+		msg TEXT NOT NULL
+	);
+	CREATE UNIQUE INDEX IF NOT EXISTS messages_channel ON messages (channel, channel_seq)`)
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	// databases created before private channels lack the column
+	var private bool
+	err = db.QueryRowContext(ctx, "SELECT COUNT(*) > 0 FROM pragma_table_info('channels') WHERE name = 'private'").Scan(&private)
+	if err == nil && !private {
+		_, err = db.ExecContext(ctx, "ALTER TABLE channels ADD COLUMN private INTEGER NOT NULL DEFAULT 0")
+	}
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	return &SQLiteStore{db: db}, nil
+}
+
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
+
+func (s *SQLiteStore) Append(ctx context.Context, msg *Message) error {
+	// with a single connection the same seq can't be assigned twice
+	return s.db.QueryRowContext(ctx, `INSERT INTO messages (id, channel, channel_seq, author, msg)
+		SELECT ?, ?, COALESCE(MAX(channel_seq), 0) + 1, ?, ? FROM messages WHERE channel = ?
+		RETURNING channel_seq`, msg.Id, msg.Channel, msg.Author, msg.Msg, msg.Channel).Scan(&msg.Seq)
+}
+
+func (s *SQLiteStore) Get(ctx context.Context, id string) (*Message, error) {
+	var msg Message
+	err := s.db.QueryRowContext(ctx, "SELECT id, channel, channel_seq, author, msg FROM messages WHERE id = ?", id).Scan(&msg.Id, &msg.Channel, &msg.Seq, &msg.Author, &msg.Msg)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+func (s *SQLiteStore) List(ctx context.Context, opts ListOptions) ([]*Message, error) {
+	var where []string
+	var args []interface{}
+	if opts.Channel != "" {
+		where = append(where, "channel = ?")
+		args = append(args, opts.Channel)
+	}
+	if opts.After != "" {
+		seq, err := s.seq(ctx, opts.After)
+		if err != nil {
+			return nil, err
+		}
+		where = append(where, "seq > ?")
+		args = append(args, seq)
+	}
+	if opts.Before != "" {
+		seq, err := s.seq(ctx, opts.Before)
+		if err != nil {
+			return nil, err
+		}
+		where = append(where, "seq < ?")
+		args = append(args, seq)
+	}
+	query := "SELECT id, channel, channel_seq, author, msg FROM messages"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	if opts.FromEnd {
+		query += " ORDER BY seq DESC"
+	} else {
+		query += " ORDER BY seq ASC"
+	}
+	// a filter is matched while reading the rows, the limit can't be left to
+	// the query
+	if opts.Limit > 0 && opts.Filter == nil {
+		query += " LIMIT ?"
+		args = append(args, opts.Limit)
+	}
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []*Message{}
+	for rows.Next() && (opts.Limit == 0 || len(messages) < opts.Limit) {
+		var msg Message
+		if err := rows.Scan(&msg.Id, &msg.Channel, &msg.Seq, &msg.Author, &msg.Msg); err != nil {
+			return nil, err
+		}
+		if opts.Filter.Match(&msg) {
+			messages = append(messages, &msg)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if opts.FromEnd {
+		// restore the append order
+		for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+			messages[i], messages[j] = messages[j], messages[i]
+		}
+	}
+	return messages, nil
+}
+
+func (s *SQLiteStore) seq(ctx context.Context, id string) (int64, error) {
+	var seq int64
+	err := s.db.QueryRowContext(ctx, "SELECT seq FROM messages WHERE id = ?", id).Scan(&seq)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrMessageNotFound
+	}
+	return seq, err
+}
+
+func (s *SQLiteStore) CreateChannel(ctx context.Context, channel *Channel) error {
+	_, err := s.db.ExecContext(ctx, "INSERT INTO channels (id, name, private) VALUES (?, ?, ?)", channel.Id, channel.Name, channel.Private)
+	return err
+}
+
+func (s *SQLiteStore) GetChannel(ctx context.Context, id string) (*Channel, error) {
+	var channel Channel
+	err := s.db.QueryRowContext(ctx, "SELECT id, name, private FROM channels WHERE id = ?", id).Scan(&channel.Id, &channel.Name, &channel.Private)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrChannelNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &channel, nil
+}
+
+func (s *SQLiteStore) ListChannels(ctx context.Context) ([]*Channel, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT id, name, private FROM channels ORDER BY seq ASC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	channels := []*Channel{}
+	for rows.Next() {
+		var channel Channel
+		if err := rows.Scan(&channel.Id, &channel.Name, &channel.Private); err != nil {
+			return nil, err
+		}
+		channels = append(channels, &channel)
+	}
+	return channels, rows.Err()
+}
+
+func (s *SQLiteStore) AddChannelMember(ctx context.Context, channel string, subject string) error {
+	_, err := s.db.ExecContext(ctx, "INSERT OR IGNORE INTO channel_members (channel, subject) VALUES (?, ?)", channel, subject)
+	return err
+}
+
+func (s *SQLiteStore) IsChannelMember(ctx context.Context, channel string, subject string) (bool, error) {
+	var member bool
+	err := s.db.QueryRowContext(ctx, "SELECT COUNT(*) > 0 FROM channel_members WHERE channel = ? AND subject = ?", channel, subject).Scan(&member)
+	return member, err
+}
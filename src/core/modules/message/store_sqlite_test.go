@@ -0,0 +1,69 @@
+package message
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+func TestSQLiteStoreHistory(t *testing.T) {
+	s, err := NewSQLiteStore(context.Background(), filepath.Join(t.TempDir(), "messages.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	testMessageHistory(t, s)
+}
+
+func TestSQLiteStoreMigratesChannels(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "messages.db")
+
+	// the channels table as created before private channels
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.ExecContext(ctx, `CREATE TABLE channels (
+		seq INTEGER PRIMARY KEY AUTOINCREMENT,
+		id TEXT NOT NULL UNIQUE,
+		name TEXT NOT NULL
+	);
+	INSERT INTO channels (id, name) VALUES ('general', 'general')`)
+	_ = db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewSQLiteStore(ctx, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	if channel, err := s.GetChannel(ctx, "general"); err != nil || channel.Private {
+		t.Fatalf("got %+v, %v", channel, err)
+	}
+	if err := s.CreateChannel(ctx, &Channel{Id: "secret", Name: "secret", Private: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddChannelMember(ctx, "secret", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	// adding a member twice is a no-op
+	if err := s.AddChannelMember(ctx, "secret", "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	if channel, err := s.GetChannel(ctx, "secret"); err != nil || !channel.Private {
+		t.Fatalf("got %+v, %v", channel, err)
+	}
+	if member, err := s.IsChannelMember(ctx, "secret", "alice"); err != nil || !member {
+		t.Errorf("alice isn't a member: %v", err)
+	}
+	if member, err := s.IsChannelMember(ctx, "secret", "bob"); err != nil || member {
+		t.Errorf("bob is a member: %v", err)
+	}
+}
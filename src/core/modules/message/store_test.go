@@ -0,0 +1,83 @@
+package message
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// appendMessages appends n messages to channel and returns them
+func appendMessages(t *testing.T, s MessageStore, channel string, n int) []*Message {
+	t.Helper()
+
+	messages := make([]*Message, n)
+	for i := range messages {
+		messages[i] = &Message{Id: fmt.Sprintf("%s-%d", channel, i), Channel: channel, Author: "alice", Msg: fmt.Sprint(i)}
+		if err := s.Append(context.Background(), messages[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return messages
+}
+
+// ids returns the ids of messages, for comparisons
+func ids(messages []*Message) string {
+	var ids []string
+	for _, msg := range messages {
+		ids = append(ids, msg.Id)
+	}
+	return fmt.Sprint(ids)
+}
+
+// testMessageHistory checks the history of a store large enough for 10
+// messages
+func testMessageHistory(t *testing.T, s MessageStore) {
+	ctx := context.Background()
+	general := appendMessages(t, s, "general", 5)
+	other := appendMessages(t, s, "other", 2)
+
+	// seqs are per channel
+	if general[4].Seq != 5 || other[1].Seq != 2 {
+		t.Fatalf("seqs %d and %d, want 5 and 2", general[4].Seq, other[1].Seq)
+	}
+
+	got, err := s.Get(ctx, general[2].Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *got != *general[2] {
+		t.Errorf("got %+v, want %+v", got, general[2])
+	}
+	if _, err := s.Get(ctx, "unknown"); !errors.Is(err, ErrMessageNotFound) {
+		t.Errorf("got %v for an unknown id", err)
+	}
+
+	for _, tt := range []struct {
+		name string
+		opts ListOptions
+		want []*Message
+	}{
+		{"all", ListOptions{}, append(append([]*Message{}, general...), other...)},
+		{"channel", ListOptions{Channel: "general"}, general},
+		{"after", ListOptions{Channel: "general", After: general[1].Id}, general[2:]},
+		{"before", ListOptions{Channel: "general", Before: general[3].Id}, general[:3]},
+		{"window", ListOptions{Channel: "general", After: general[0].Id, Before: general[4].Id}, general[1:4]},
+		{"limit", ListOptions{Channel: "general", Limit: 2}, general[:2]},
+		{"limit from end", ListOptions{Channel: "general", Limit: 2, FromEnd: true}, general[3:]},
+	} {
+		messages, err := s.List(ctx, tt.opts)
+		if err != nil {
+			t.Fatalf("%s: %s", tt.name, err)
+		}
+		if ids(messages) != ids(tt.want) {
+			t.Errorf("%s: listed %s, want %s", tt.name, ids(messages), ids(tt.want))
+		}
+	}
+
+	for _, opts := range []ListOptions{{After: "unknown"}, {Before: "unknown"}} {
+		if _, err := s.List(ctx, opts); !errors.Is(err, ErrMessageNotFound) {
+			t.Errorf("listing %+v: got %v", opts, err)
+		}
+	}
+}
@@ -1,12 +1,54 @@
 package message
 
+import "sync/atomic"
+
 type Message struct {
-	Id  string
-	Msg string
+	Id      string `json:"id"`
+	Channel string `json:"channel"`
+	Author  string `json:"author,omitempty"`
+	Msg     string `json:"msg"`
+	// Seq orders the messages of a channel, it is assigned by the store. Stores
+	// local to a server instance only order the messages sent through it,
+	// messages of other instances may reuse a seq.
+	Seq int32 `json:"seq"`
+	// Missed is the number of events dropped for the receiving subscription
+	// since the previous event it got, it is only set on delivered events. A
+	// replay that can't start right after its since message counts one more.
+	Missed int32 `json:"-"`
+	// Skipped is the number of messages of the channel stored before this one
+	// that failed to be published, subscribers count them missed
+	Skipped int32 `json:"skipped,omitempty"`
+}
+
+type Channel struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+	// Private channels are only visible to their members
+	Private bool `json:"private"`
 }
 
 type OnMessageSubscriber struct {
-	Stop   <-chan struct{}
-	Events chan<- *Message
-	Filter string
+	Id      string
+	Channel string
+	Stop    <-chan struct{}
+	Events  chan<- *Message
+	Filter  *Filter
+
+	delivered atomic.Uint64
+	dropped   atomic.Uint64
+}
+
+// Delivered returns the number of events sent to the subscriber
+func (s *OnMessageSubscriber) Delivered() uint64 {
+	return s.delivered.Load()
+}
+
+// Dropped returns the number of events dropped for the subscriber
+func (s *OnMessageSubscriber) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// matches reports whether the event passes the subscriber's filter.
+func (s *OnMessageSubscriber) matches(e *Message) bool {
+	return s.Filter.Match(e)
 }
@@ -1,26 +1,97 @@
 package core
 
 import (
+	"sample-subscription/src/core/modules/admin"
 	"sample-subscription/src/core/modules/message"
+	"sample-subscription/src/subscription/transport"
 )
 
+// defaultHistorySize is the number of messages kept by the default store
+const defaultHistorySize = 1000
+
 type Resolver struct {
 	message.MessageResolver
+	admin.AdminResolver
+
+	connections *transport.Registry
+	localStore  bool
+	// closeBroker stops the default broker, nil when the caller provided one
+	closeBroker func() error
+}
+
+// Option applies configuration when the root resolver is created
+type Option func(*Resolver)
+
+// WithBroker replaces the default in-memory broker. The caller owns the broker
+// and is responsible for running and closing it.
+func WithBroker(broker message.Broker) Option {
+	return func(r *Resolver) {
+		r.MessageResolver.Broker = broker
+	}
+}
+
+// WithStore replaces the default in-memory message history.
+func WithStore(store message.MessageStore) Option {
+	return func(r *Resolver) {
+		r.MessageResolver.Store = store
+	}
+}
+
+// WithLocalStore is for brokers sharing events between server instances when
+// each instance keeps its own store. Channels created on other instances are
+// accepted without validation.
+func WithLocalStore() Option {
+	return func(r *Resolver) {
+		r.localStore = true
+	}
+}
+
+// WithConnectionRegistry lets the admin operations list and terminate the
+// websocket connections tracked by registry.
+func WithConnectionRegistry(registry *transport.Registry) Option {
+	return func(r *Resolver) {
+		r.connections = registry
+	}
 }
 
-func NewResolver() *Resolver {
-	r := Resolver{
-		// Option 1
-		MessageResolver: message.MessageResolver{
-			MessageEvents:       make(chan *message.Message),
-			HelloSaidSubscriber: make(chan *message.OnMessageSubscriber),
-		},
+func NewResolver(opts ...Option) *Resolver {
+	r := Resolver{}
+	for _, opt := range opts {
+		opt(&r)
 	}
-	// // Option 2
-	// r.MessageResolver.MessageEvents = make(chan *message.Message)
-	// r.MessageResolver.HelloSaidSubscriber = make(chan *message.OnMessageSubscriber)
 
-	go r.MessageResolver.BroadcastMessageEvent()
+	if r.MessageResolver.Broker == nil {
+		broker := message.NewMemoryBroker()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			broker.BroadcastMessageEvent()
+		}()
+		r.MessageResolver.Broker = broker
+		r.closeBroker = func() error {
+			err := broker.Close()
+			<-done
+			return err
+		}
+	}
+
+	if r.MessageResolver.Store == nil {
+		r.MessageResolver.Store = message.NewMemoryStore(defaultHistorySize)
+	}
+
+	r.MessageResolver = message.NewMessageResolver(r.MessageResolver.Broker, r.MessageResolver.Store)
+	r.MessageResolver.LocalStore = r.localStore
+	r.AdminResolver = admin.NewAdminResolver(r.MessageResolver.Registry, r.connections)
 
 	return &r
 }
+
+// Close stops the default broker and waits for its broadcast loop to return,
+// open subscriptions end. Brokers provided with WithBroker are left to the
+// caller. Close may be called more than once.
+func (r *Resolver) Close() error {
+	if r.closeBroker == nil {
+		return nil
+	}
+	return r.closeBroker()
+}
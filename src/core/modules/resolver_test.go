@@ -0,0 +1,81 @@
+package core
+
+import (
+	"context"
+	"runtime"
+	"sample-subscription/src/core/modules/message"
+	"testing"
+	"time"
+
+	graphql "github.com/graph-gophers/graphql-go"
+)
+
+// receive returns the next event of a subscription, ok is false once it ended
+func receive(t *testing.T, events <-chan *message.Message) (msg *message.Message, ok bool) {
+	t.Helper()
+
+	select {
+	case msg, ok = <-events:
+		return msg, ok
+	case <-time.After(2 * time.Second):
+		t.Fatal("no event received")
+		return nil, false
+	}
+}
+
+func TestCloseReleasesSubscriptions(t *testing.T) {
+	baseline := runtime.NumGoroutine()
+
+	r := NewResolver()
+	ctx := context.Background()
+	channel, err := r.CreateChannel(ctx, message.CreateChannelArgs{Name: "general"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := r.SendMessage(ctx, message.SendMessageArgs{Channel: graphql.ID(channel.Id), Msg: "first"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// operations that never end, live and replaying the history
+	var subscriptions []<-chan *message.Message
+	for _, since := range []*string{nil, nil, &first.Id} {
+		events, err := r.OnMessage(ctx, message.OnMessageArgs{Channel: graphql.ID(channel.Id), Since: since})
+		if err != nil {
+			t.Fatal(err)
+		}
+		subscriptions = append(subscriptions, events)
+	}
+	if _, err := r.SendMessage(ctx, message.SendMessageArgs{Channel: graphql.ID(channel.Id), Msg: "second"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, events := range subscriptions {
+		if msg, ok := receive(t, events); !ok || msg.Msg != "second" {
+			t.Fatalf("received %+v, want the second message", msg)
+		}
+	}
+	if n := len(r.Registry.List()); n != len(subscriptions) {
+		t.Fatalf("%d subscriptions listed, want %d", n, len(subscriptions))
+	}
+
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for _, events := range subscriptions {
+		if msg, ok := receive(t, events); ok {
+			t.Fatalf("received %+v, want the subscription to end", msg)
+		}
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(r.Registry.List()) != 0 || runtime.NumGoroutine() > baseline {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d subscriptions listed and %d goroutines running, want none and %d", len(r.Registry.List()), runtime.NumGoroutine(), baseline)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := r.Close(); err != nil {
+		t.Errorf("second close: %s", err)
+	}
+}
@@ -1,12 +1,23 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"sample-subscription/src/auth"
 	core "sample-subscription/src/core/modules"
+	"sample-subscription/src/core/modules/admin"
+	"sample-subscription/src/core/modules/message"
 	"sample-subscription/src/subscription/graphqlws"
+	"sample-subscription/src/subscription/transport"
 	"strconv"
+	"syscall"
+	"time"
 
 	graphql "github.com/graph-gophers/graphql-go"
 	"github.com/graph-gophers/graphql-go/relay"
@@ -14,6 +25,10 @@ import (
 
 var httpPort = 8787
 
+// adminAddr is where the admin API listens, it is disabled when empty
+var adminAddr = ""
+var shutdownTimeout = 10 * time.Second
+
 func init() {
 	port := os.Getenv("HTTP_PORT")
 	if port != "" {
@@ -23,27 +38,136 @@ func init() {
 			panic(err)
 		}
 	}
+
+	port = os.Getenv("ADMIN_HTTP_PORT")
+	if port != "" {
+		adminPort, err := strconv.Atoi(port)
+		if err != nil {
+			panic(err)
+		}
+		// the admin API doesn't authenticate, it only listens on loopback
+		// unless ADMIN_HTTP_ADDR says otherwise
+		adminAddr = fmt.Sprintf("127.0.0.1:%d", adminPort)
+	}
+	if addr := os.Getenv("ADMIN_HTTP_ADDR"); addr != "" {
+		adminAddr = addr
+	}
+
+	timeout := os.Getenv("SHUTDOWN_TIMEOUT")
+	if timeout != "" {
+		var err error
+		shutdownTimeout, err = time.ParseDuration(timeout)
+		if err != nil {
+			panic(err)
+		}
+	}
 }
 
 func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	schema, err := os.ReadFile("./schema.graphql")
 	if err != nil {
 		panic(err)
 	}
 
 	// init graphQL schema
-	resolver := core.NewResolver()
+	registry := transport.NewRegistry()
+	opts := []core.Option{core.WithConnectionRegistry(registry)}
+	// brokers outlive the signal so draining operations still get their events
+	brokerCtx, stopBroker := context.WithCancel(context.Background())
+	defer stopBroker()
+	broker, err := newBroker(brokerCtx)
+	if err != nil {
+		panic(err)
+	}
+	if broker != nil {
+		opts = append(opts, core.WithBroker(broker))
+		// the stores are local to each instance, other brokers share events
+		// between instances
+		if _, local := broker.(*message.MemoryBroker); !local {
+			opts = append(opts, core.WithLocalStore())
+		}
+	}
+	store, err := newStore(context.Background())
+	if err != nil {
+		panic(err)
+	}
+	if store != nil {
+		opts = append(opts, core.WithStore(store))
+	}
+	resolver := core.NewResolver(opts...)
 	s, err := graphql.ParseSchema(string(schema), resolver, graphql.UseFieldResolvers())
 	if err != nil {
 		panic(err)
 	}
 
 	// graphQL handler
-	graphQLHandler := graphqlws.NewHandlerFunc(s, &relay.Handler{Schema: s})
+	handlerOpts := []graphqlws.Option{graphqlws.WithRegistry(registry)}
+	authenticator, err := newAuthenticator()
+	if err != nil {
+		panic(err)
+	}
+	if authenticator != nil {
+		// @auth directives are only enforced when requests can authenticate,
+		// admin fields check the role themselves and stay closed otherwise
+		handlerOpts = append(handlerOpts,
+			graphqlws.WithAuthenticator(authenticator),
+			graphqlws.WithAuthorizer(auth.NewAuthorizer(s)),
+		)
+	}
+	originPolicy, err := newOriginPolicy()
+	if err != nil {
+		panic(err)
+	}
+	if originPolicy != nil {
+		handlerOpts = append(handlerOpts, graphqlws.WithOriginPolicy(originPolicy))
+	}
+	graphQLHandler := graphqlws.NewHandlerFunc(s, &relay.Handler{Schema: s}, handlerOpts...)
 	http.HandleFunc("/graphql", graphQLHandler)
 
-	// start HTTP server
-	if err := http.ListenAndServe(fmt.Sprintf(":%d", httpPort), nil); err != nil {
-		panic(err)
+	servers := []*http.Server{{Addr: fmt.Sprintf(":%d", httpPort)}}
+	// the admin API is served on its own address
+	if adminAddr != "" {
+		adminHandler := admin.NewHandler(resolver.MessageResolver.Registry, registry)
+		servers = append(servers, &http.Server{Addr: adminAddr, Handler: http.StripPrefix("/admin", adminHandler)})
+	}
+
+	// start HTTP servers
+	for _, srv := range servers {
+		go func(srv *http.Server) {
+			if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				panic(err)
+			}
+		}(srv)
+	}
+
+	<-ctx.Done()
+	stop()
+	log.Printf("shutting down, waiting up to %s for connections to drain", shutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	// websocket connections are hijacked, the HTTP servers don't track them.
+	// The registry ends the streams of the HTTP transports as well, they would
+	// hold the HTTP servers until the deadline otherwise.
+	if err := registry.Shutdown(shutdownCtx, "server shutting down"); err != nil {
+		log.Printf("websocket connections closed before draining: %s", err)
+	}
+	if closer, ok := broker.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			log.Printf("unable to close broker: %s", err)
+		}
+	}
+	stopBroker()
+	if err := resolver.Close(); err != nil {
+		log.Printf("unable to close default broker: %s", err)
+	}
+	for _, srv := range servers {
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Printf("HTTP server %s closed before draining: %s", srv.Addr, err)
+			_ = srv.Close()
+		}
 	}
 }
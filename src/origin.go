@@ -0,0 +1,37 @@
+package main
+
+import (
+	"os"
+	"sample-subscription/src/subscription/graphqlws"
+	"strings"
+)
+
+// newOriginPolicy builds the origin allow-list configured by the environment,
+// nil when only same origin requests are allowed. See
+// graphqlws.ParseOriginPolicy for the entry formats.
+//
+//	ALLOWED_ORIGINS       comma separated entries
+//	ALLOWED_ORIGINS_FILE  file with one entry per line, # starts a comment
+func newOriginPolicy() (*graphqlws.OriginPolicy, error) {
+	var entries []string
+
+	if origins := os.Getenv("ALLOWED_ORIGINS"); origins != "" {
+		entries = append(entries, strings.Split(origins, ",")...)
+	}
+
+	if path := os.Getenv("ALLOWED_ORIGINS_FILE"); path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range strings.Split(string(b), "\n") {
+			line, _, _ = strings.Cut(line, "#")
+			entries = append(entries, line)
+		}
+	}
+
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	return graphqlws.ParseOriginPolicy(entries)
+}
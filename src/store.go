@@ -0,0 +1,21 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sample-subscription/src/core/modules/message"
+)
+
+// newStore builds the message store selected by the STORE environment
+// variable. An empty value keeps the resolver's default in-memory store.
+func newStore(ctx context.Context) (message.MessageStore, error) {
+	switch backend := os.Getenv("STORE"); backend {
+	case "", "memory":
+		return nil, nil
+	case "sqlite":
+		return message.NewSQLiteStore(ctx, getenv("SQLITE_PATH", "messages.db"))
+	default:
+		return nil, fmt.Errorf("unsupported store %q", backend)
+	}
+}
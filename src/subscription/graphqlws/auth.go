@@ -0,0 +1,57 @@
+package graphqlws
+
+import (
+	"context"
+	"net/http"
+	"sample-subscription/src/auth"
+	"sample-subscription/src/subscription/transport"
+)
+
+// authenticate adds the principal of authorization to ctx. Requests without
+// credentials stay anonymous, invalid credentials are an error.
+func authenticate(ctx context.Context, authenticator auth.Authenticator, authorization string) (context.Context, error) {
+	if authorization == "" {
+		return ctx, nil
+	}
+
+	p, err := authenticator.Authenticate(ctx, authorization)
+	if err != nil {
+		return nil, err
+	}
+	return auth.WithPrincipal(ctx, p), nil
+}
+
+// authenticateInit wraps the transport InitFunc to authenticate the
+// Authorization value of the connection_init payload first. The connection is
+// closed when the credentials expire.
+func authenticateInit(authenticator auth.Authenticator, next transport.WebsocketInitFunc) transport.WebsocketInitFunc {
+	return func(ctx context.Context, initPayload transport.InitPayload) (context.Context, error) {
+		ctx, err := authenticate(ctx, authenticator, initPayload.Authorization())
+		if err != nil {
+			return nil, err
+		}
+		if p := auth.GetPrincipal(ctx); p != nil && !p.ExpiresAt.IsZero() {
+			ctx = transport.WithExpiry(ctx, p.ExpiresAt)
+		}
+
+		if next != nil {
+			return next(ctx, initPayload)
+		}
+		return ctx, nil
+	}
+}
+
+// authenticateHTTP authenticates the Authorization header of plain HTTP
+// requests
+func authenticateHTTP(authenticator auth.Authenticator, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, err := authenticate(r.Context(), authenticator, r.Header.Get("Authorization"))
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			transport.SendErrorf(w, http.StatusUnauthorized, "%s", err)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
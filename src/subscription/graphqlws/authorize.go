@@ -0,0 +1,67 @@
+package graphqlws
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sample-subscription/src/auth"
+	"sample-subscription/src/subscription/transport"
+)
+
+// authorizedService rejects websocket operations the authorizer denies. The
+// transport answers the rejected operation with an error message and keeps the
+// connection open.
+type authorizedService struct {
+	GraphQLService
+	authorizer *auth.Authorizer
+}
+
+func (s authorizedService) Subscribe(ctx context.Context, document string, operationName string, variableValues map[string]interface{}) (<-chan interface{}, error) {
+	if err := s.authorizer.Authorize(ctx, document, operationName); err != nil {
+		return nil, err
+	}
+	return s.GraphQLService.Subscribe(ctx, document, operationName, variableValues)
+}
+
+// maxRequestBodySize is the size of the largest plain HTTP operation read to be
+// authorized
+const maxRequestBodySize = 1 << 20
+
+// authorizeHTTP rejects plain HTTP operations the authorizer denies with a
+// standard GraphQL error response
+func authorizeHTTP(authorizer *auth.Authorizer, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestBodySize))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			transport.SendErrorf(w, http.StatusRequestEntityTooLarge, "request body larger than %d bytes", tooLarge.Limit)
+			return
+		}
+		if err != nil {
+			transport.SendErrorf(w, http.StatusBadRequest, "failed to read request body")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		var params struct {
+			Query         string `json:"query"`
+			OperationName string `json:"operationName"`
+		}
+		// decoded like the handler does, trailing data included, so both read
+		// the same operation
+		if err := json.NewDecoder(bytes.NewReader(body)).Decode(&params); err != nil {
+			transport.SendErrorf(w, http.StatusBadRequest, "failed to decode request body")
+			return
+		}
+		if err := authorizer.Authorize(r.Context(), params.Query, params.OperationName); err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			transport.SendError(w, http.StatusOK, err)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
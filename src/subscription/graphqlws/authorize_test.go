@@ -0,0 +1,110 @@
+package graphqlws
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sample-subscription/src/auth"
+	"sample-subscription/src/subscription/transport"
+	"strings"
+	"testing"
+
+	graphql "github.com/graph-gophers/graphql-go"
+)
+
+const testSchema = `
+directive @auth(requires: Role!) on FIELD_DEFINITION
+
+enum Role {
+  ADMIN
+}
+
+schema {
+  query: Query
+  mutation: Mutation
+}
+
+type Query {
+  hello: String!
+}
+
+type Mutation {
+  terminateConnection(id: String!): Boolean! @auth(requires: ADMIN)
+}
+`
+
+func TestAuthorizeHTTP(t *testing.T) {
+	authorizer := auth.NewAuthorizer(graphql.MustParseSchema(testSchema, nil))
+
+	tests := []struct {
+		name    string
+		body    string
+		allowed bool
+	}{
+		{name: "allowed", body: `{"query": "{ hello }"}`, allowed: true},
+		{name: "denied", body: `{"query": "mutation { terminateConnection(id: \"x\") }"}`},
+		// the handler decodes the first JSON value and ignores the rest
+		{name: "trailing data", body: `{"query": "mutation { terminateConnection(id: \"x\") }"} {}`},
+		{name: "unparsable query", body: `{"query": "mutation { terminateConnection(id: \"x\") } /* */"}`},
+		{name: "malformed body", body: `{"query": `},
+		{name: "oversized body", body: `{"query": "{ hello }", "variables": {"x": "` + strings.Repeat("x", maxRequestBodySize) + `"}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			served := false
+			h := authorizeHTTP(authorizer, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				served = true
+			}))
+
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/graphql", strings.NewReader(tt.body)))
+			if served != tt.allowed {
+				t.Errorf("served %t, want %t", served, tt.allowed)
+			}
+		})
+	}
+}
+
+// contextService records the context of the operations it resolves
+type contextService struct {
+	contexts chan context.Context
+}
+
+func (s contextService) Subscribe(ctx context.Context, document string, operationName string, variableValues map[string]interface{}) (<-chan interface{}, error) {
+	s.contexts <- ctx
+	payloads := make(chan interface{}, 1)
+	payloads <- map[string]interface{}{"data": map[string]interface{}{"hello": "Hello"}}
+	close(payloads)
+	return payloads, nil
+}
+
+func TestAuthorizedOperationsKeepTheConnectionContext(t *testing.T) {
+	service := contextService{contexts: make(chan context.Context, 1)}
+	srv := httptest.NewServer(NewHandlerFunc(service, http.NotFoundHandler(),
+		WithAuthenticator(auth.StaticTokens{"alice-token": {Subject: "alice"}}),
+		WithAuthorizer(auth.NewAuthorizer(graphql.MustParseSchema(testSchema, nil))),
+	))
+	defer srv.Close()
+
+	c := dial(t, srv, map[string]interface{}{"Authorization": "alice-token", "client": "test"})
+	subscribe(t, c, "1", "{ hello }")
+	if msg := read(t, c); msg["type"] != "next" {
+		t.Fatalf("got %v, want next", msg)
+	}
+
+	ctx := <-service.contexts
+	if p := auth.GetPrincipal(ctx); p == nil || p.Subject != "alice" {
+		t.Errorf("principal %+v, want alice", p)
+	}
+	if got := transport.GetInitPayload(ctx)["client"]; got != "test" {
+		t.Errorf("init payload client %v, want test", got)
+	}
+	if got := transport.GetRemoteAddr(ctx); got != c.LocalAddr().String() {
+		t.Errorf("remote address %q, want %q", got, c.LocalAddr())
+	}
+	if got := transport.GetSubprotocol(ctx); got != "graphql-transport-ws" {
+		t.Errorf("subprotocol %q, want graphql-transport-ws", got)
+	}
+	if transport.GetConnectionID(ctx) == "" || transport.GetOperationID(ctx) != "1" {
+		t.Errorf("connection %q and operation %q, want the ids of the operation", transport.GetConnectionID(ctx), transport.GetOperationID(ctx))
+	}
+}
@@ -2,6 +2,7 @@ package graphqlws
 
 import (
 	"net/http"
+	"sample-subscription/src/auth"
 	"sample-subscription/src/subscription/transport"
 	"time"
 
@@ -10,9 +11,7 @@ import (
 
 type GraphQLService = transport.GraphQLService
 
-var defaultUpgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool { return true },
-}
+var defaultUpgrader = websocket.Upgrader{}
 
 var defaultTransport = transport.Websocket{
 	Upgrader:              defaultUpgrader,
@@ -20,6 +19,10 @@ var defaultTransport = transport.Websocket{
 	KeepAlivePingInterval: 10 * time.Second,
 }
 
+var defaultMultipartTransport = transport.Multipart{
+	HeartbeatInterval: 5 * time.Second,
+}
+
 // Option applies configuration when a graphql websocket connection is handled
 type Option func(*handlerConfig)
 
@@ -29,23 +32,156 @@ func WithWebsocketTransport(transport *transport.Websocket) Option {
 	}
 }
 
-// NewHandlerFunc returns an http.HandlerFunc that supports GraphQL over websockets
+// WithSSETransport serves requests accepting text/event-stream with transport
+// instead of a default SSE transport. Its Registry is left as is, WithRegistry
+// doesn't apply to it.
+func WithSSETransport(transport *transport.SSE) Option {
+	return func(cfg *handlerConfig) {
+		cfg.SSE = transport
+	}
+}
+
+// WithMultipartTransport serves requests accepting multipart/mixed with
+// transport instead of the default multipart transport
+func WithMultipartTransport(transport *transport.Multipart) Option {
+	return func(cfg *handlerConfig) {
+		cfg.Multipart = transport
+	}
+}
+
+// WithLongPollTransport serves requests with the transport=long-poll query
+// parameter with transport instead of a default long-polling transport. Its
+// Registry is left as is, WithRegistry doesn't apply to it.
+func WithLongPollTransport(transport *transport.LongPoll) Option {
+	return func(cfg *handlerConfig) {
+		cfg.LongPoll = transport
+	}
+}
+
+// WithRegistry tracks the websocket connections and HTTP streams of the handler
+// in registry, except those of the SSE and long-polling transports passed in
+func WithRegistry(registry *transport.Registry) Option {
+	return func(cfg *handlerConfig) {
+		cfg.Registry = registry
+	}
+}
+
+// WithAuthenticator authenticates websocket connections with the Authorization
+// value of their connection_init payload and HTTP requests with their
+// Authorization header. The principal is available to resolvers through
+// auth.GetPrincipal.
+func WithAuthenticator(authenticator auth.Authenticator) Option {
+	return func(cfg *handlerConfig) {
+		cfg.Authenticator = authenticator
+	}
+}
+
+// WithAuthorizer enforces the @auth directives of the schema on websocket
+// operations and HTTP requests alike. Authentication, when configured, runs
+// first so the principal is known.
+func WithAuthorizer(authorizer *auth.Authorizer) Option {
+	return func(cfg *handlerConfig) {
+		cfg.Authorizer = authorizer
+	}
+}
+
+// WithOriginPolicy allows websocket connections and cross origin HTTP requests
+// from the origins of policy. Without it only same origin browser requests are
+// allowed.
+func WithOriginPolicy(policy *OriginPolicy) Option {
+	return func(cfg *handlerConfig) {
+		cfg.OriginPolicy = policy
+	}
+}
+
+// NewHandlerFunc returns an http.HandlerFunc that supports GraphQL over websockets,
+// Server-Sent Events, multipart HTTP responses and long-polling
 func NewHandlerFunc(svc GraphQLService, httpHandler http.Handler, opts ...Option) http.HandlerFunc {
 	cfg := handlerConfig{
-		Transport: &defaultTransport,
+		Transport:    &defaultTransport,
+		Multipart:    &defaultMultipartTransport,
+		OriginPolicy: &OriginPolicy{},
 	}
 	for _, opt := range opts {
 		opt(&cfg)
 	}
-	return func(w http.ResponseWriter, r *http.Request) {
-		if cfg.Transport.Supports(r) {
+	// SSE and long-polling transports keep their streams and subscriptions,
+	// they can't be copied: every handler gets its own
+	if cfg.SSE == nil {
+		cfg.SSE = &transport.SSE{
+			KeepAliveInterval:  12 * time.Second,
+			ReservationTimeout: 30 * time.Second,
+			Registry:           cfg.Registry,
+		}
+	}
+	if cfg.LongPoll == nil {
+		cfg.LongPoll = &transport.LongPoll{
+			PollTimeout: 25 * time.Second,
+			IdleTimeout: time.Minute,
+			BufferSize:  100,
+			Registry:    cfg.Registry,
+		}
+	}
+
+	// the transports may be shared with other handlers, configure copies
+	t := *cfg.Transport
+	cfg.Transport = &t
+	multipart := *cfg.Multipart
+	cfg.Multipart = &multipart
+	if cfg.Registry != nil {
+		t.Registry = cfg.Registry
+		multipart.Registry = cfg.Registry
+	}
+	if t.Upgrader.CheckOrigin == nil {
+		t.Upgrader.CheckOrigin = cfg.OriginPolicy.Allowed
+	}
+	if cfg.Authorizer != nil {
+		svc = authorizedService{GraphQLService: svc, authorizer: cfg.Authorizer}
+		httpHandler = authorizeHTTP(cfg.Authorizer, httpHandler)
+	}
+	sseHandler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg.SSE.Do(w, r, svc)
+	}))
+	multipartHandler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg.Multipart.Do(w, r, svc)
+	}))
+	longPollHandler := http.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cfg.LongPoll.Do(w, r, svc)
+	}))
+	if cfg.Authenticator != nil {
+		t.InitFunc = authenticateInit(cfg.Authenticator, t.InitFunc)
+		httpHandler = authenticateHTTP(cfg.Authenticator, httpHandler)
+		sseHandler = authenticateHTTP(cfg.Authenticator, sseHandler)
+		multipartHandler = authenticateHTTP(cfg.Authenticator, multipartHandler)
+		longPollHandler = authenticateHTTP(cfg.Authenticator, longPollHandler)
+	}
+
+	handler := checkOrigin(cfg.OriginPolicy, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case cfg.Transport.Supports(r):
 			cfg.Transport.Do(w, r, svc)
-		} else {
+		// long-polling requests carry a token like single connection SSE
+		// requests, they are told apart first
+		case cfg.LongPoll.Supports(r):
+			longPollHandler.ServeHTTP(w, r)
+		case cfg.SSE.Supports(r):
+			sseHandler.ServeHTTP(w, r)
+		case cfg.Multipart.Supports(r):
+			multipartHandler.ServeHTTP(w, r)
+		default:
 			httpHandler.ServeHTTP(w, r)
 		}
-	}
+	}))
+	return handler.ServeHTTP
 }
 
 type handlerConfig struct {
-	Transport *transport.Websocket
+	Transport     *transport.Websocket
+	SSE           *transport.SSE
+	Multipart     *transport.Multipart
+	LongPoll      *transport.LongPoll
+	Registry      *transport.Registry
+	Authenticator auth.Authenticator
+	Authorizer    *auth.Authorizer
+	OriginPolicy  *OriginPolicy
 }
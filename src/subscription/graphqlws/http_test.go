@@ -0,0 +1,174 @@
+package graphqlws
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sample-subscription/src/auth"
+	core "sample-subscription/src/core/modules"
+	"sample-subscription/src/core/modules/message"
+	"sample-subscription/src/subscription/transport"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	graphql "github.com/graph-gophers/graphql-go"
+	"github.com/graph-gophers/graphql-go/relay"
+)
+
+// newServer serves the application schema until the test ends
+func newServer(t *testing.T, opts ...Option) (*httptest.Server, *core.Resolver) {
+	t.Helper()
+
+	schema, err := os.ReadFile("../../../schema.graphql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolver := core.NewResolver()
+	s := graphql.MustParseSchema(string(schema), resolver, graphql.UseFieldResolvers())
+	srv := httptest.NewServer(NewHandlerFunc(s, &relay.Handler{Schema: s}, opts...))
+	t.Cleanup(func() {
+		srv.Close()
+		_ = resolver.Close()
+	})
+	return srv, resolver
+}
+
+// dial opens a graphql-transport-ws connection acknowledged with payload
+func dial(t *testing.T, srv *httptest.Server, payload map[string]interface{}) *websocket.Conn {
+	t.Helper()
+
+	d := websocket.Dialer{Subprotocols: []string{"graphql-transport-ws"}}
+	c, _, err := d.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = c.Close() })
+
+	if err := c.WriteJSON(map[string]interface{}{"type": "connection_init", "payload": payload}); err != nil {
+		t.Fatal(err)
+	}
+	if msg := read(t, c); msg["type"] != "connection_ack" {
+		t.Fatalf("got %v, want connection_ack", msg)
+	}
+	return c
+}
+
+func read(t *testing.T, c *websocket.Conn) map[string]interface{} {
+	t.Helper()
+
+	_ = c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var msg map[string]interface{}
+	if err := c.ReadJSON(&msg); err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+func subscribe(t *testing.T, c *websocket.Conn, id string, query string) {
+	t.Helper()
+
+	err := c.WriteJSON(map[string]interface{}{"type": "subscribe", "id": id, "payload": map[string]interface{}{"query": query}})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOnMessageReadsTheConnectionPrincipal(t *testing.T) {
+	srv, resolver := newServer(t, WithAuthenticator(auth.StaticTokens{
+		"alice-token": {Subject: "alice"},
+		"bob-token":   {Subject: "bob"},
+	}))
+	alice := auth.WithPrincipal(context.Background(), &auth.Principal{Subject: "alice"})
+	private := true
+	channel, err := resolver.CreateChannel(alice, message.CreateChannelArgs{Name: "secret", Private: &private})
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := `subscription { onMessage(channel: "` + channel.Id + `") { msg author } }`
+
+	// the principal authenticated by connection_init isn't a member
+	bob := dial(t, srv, map[string]interface{}{"Authorization": "bob-token"})
+	subscribe(t, bob, "1", query)
+	if msg := read(t, bob); msg["type"] != "error" || msg["id"] != "1" {
+		t.Fatalf("got %v, want an error for operation 1", msg)
+	}
+
+	c := dial(t, srv, map[string]interface{}{"Authorization": "alice-token"})
+	subscribe(t, c, "1", query)
+	for deadline := time.Now().Add(2 * time.Second); len(resolver.Registry.List()) == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("subscription not started")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if info := resolver.Registry.List()[0]; info.ConnectionId == "" || info.OperationId != "1" {
+		t.Errorf("subscription info %+v lacks the connection details", info)
+	}
+
+	if _, err := resolver.SendMessage(alice, message.SendMessageArgs{Channel: graphql.ID(channel.Id), Msg: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	msg := read(t, c)
+	if msg["type"] != "next" {
+		t.Fatalf("got %v, want next", msg)
+	}
+	data := msg["payload"].(map[string]interface{})["data"].(map[string]interface{})
+	if got := data["onMessage"].(map[string]interface{})["author"]; got != "alice" {
+		t.Errorf("author %v, want alice", got)
+	}
+}
+
+func TestHTTPTransportsRejectOperationsThatCannotStart(t *testing.T) {
+	srv, _ := newServer(t)
+
+	for _, tt := range []struct {
+		name   string
+		target string
+		accept string
+	}{
+		{"sse", srv.URL, "text/event-stream"},
+		{"multipart", srv.URL, "multipart/mixed"},
+		{"long-poll", srv.URL + "?transport=long-poll", "application/json"},
+	} {
+		for _, query := range []string{
+			`{ nope }`,
+			`subscription { nope }`,
+			`subscription { onMessage(channel: "unknown") { msg } }`,
+		} {
+			b, _ := json.Marshal(map[string]string{"query": query})
+			req, err := http.NewRequest(http.MethodPost, tt.target, strings.NewReader(string(b)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Accept", tt.accept)
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var body struct {
+				Errors []struct {
+					Message string `json:"message"`
+				} `json:"errors"`
+			}
+			err = json.NewDecoder(res.Body).Decode(&body)
+			_ = res.Body.Close()
+			if res.StatusCode != http.StatusBadRequest || err != nil || len(body.Errors) == 0 {
+				t.Errorf("%s %s: status %d with %+v, want 400 with errors", tt.name, query, res.StatusCode, body)
+			}
+		}
+	}
+}
+
+func TestHandlerLeavesTheTransportsPassedIn(t *testing.T) {
+	sse := &transport.SSE{}
+	longPoll := &transport.LongPoll{}
+	newServer(t, WithSSETransport(sse), WithLongPollTransport(longPoll), WithRegistry(transport.NewRegistry()))
+	if sse.Registry != nil || longPoll.Registry != nil {
+		t.Error("the registry was set on the transports passed in")
+	}
+}
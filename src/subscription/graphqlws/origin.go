@@ -0,0 +1,147 @@
+package graphqlws
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sample-subscription/src/subscription/transport"
+	"strings"
+)
+
+// OriginPolicy decides which browser origins may open websocket connections
+// and send cross origin HTTP requests. Requests without an Origin header don't
+// come from a browser and same origin requests aren't cross site, both are
+// always allowed. The zero policy only allows those.
+type OriginPolicy struct {
+	matchers []originMatcher
+	// any is set by the * entry
+	any bool
+}
+
+type originMatcher func(origin *url.URL) bool
+
+// ParseOriginPolicy builds a policy from allow-list entries, an origin is
+// allowed when any entry matches it:
+//
+//	example.com                  exact host, any scheme
+//	https://example.com:8443     exact origin
+//	*.example.com                any subdomain of example.com
+//	https://*.example.com        any subdomain of example.com over https
+//	regex:https://[a-z]+\.dev    regular expression the whole origin must match
+//	*                            any origin, without credentials
+func ParseOriginPolicy(entries []string) (*OriginPolicy, error) {
+	p := &OriginPolicy{}
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if entry == "*" {
+			p.any = true
+			continue
+		}
+		m, err := parseOriginEntry(entry)
+		if err != nil {
+			return nil, err
+		}
+		p.matchers = append(p.matchers, m)
+	}
+	return p, nil
+}
+
+func parseOriginEntry(entry string) (originMatcher, error) {
+	if pattern, found := strings.CutPrefix(entry, "regex:"); found {
+		// anchored so a pattern can't match a prefix of another origin, such
+		// as https://app.example.com.evil.net
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid origin pattern %q: %w", entry, err)
+		}
+		return func(origin *url.URL) bool {
+			return re.MatchString(origin.Scheme + "://" + origin.Host)
+		}, nil
+	}
+
+	scheme, host, found := strings.Cut(entry, "://")
+	if !found {
+		scheme, host = "", entry
+	}
+	scheme, host = strings.ToLower(scheme), strings.ToLower(host)
+	if host == "" || strings.ContainsAny(host, "/?#") {
+		return nil, fmt.Errorf("invalid origin %q", entry)
+	}
+	schemeMatches := func(origin *url.URL) bool {
+		return scheme == "" || strings.EqualFold(origin.Scheme, scheme)
+	}
+
+	if suffix, found := strings.CutPrefix(host, "*."); found {
+		return func(origin *url.URL) bool {
+			return schemeMatches(origin) && strings.HasSuffix(strings.ToLower(origin.Hostname()), "."+suffix)
+		}, nil
+	}
+	return func(origin *url.URL) bool {
+		return schemeMatches(origin) && strings.EqualFold(origin.Host, host)
+	}, nil
+}
+
+// Allowed reports whether the Origin header of r is allowed
+func (p *OriginPolicy) Allowed(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if p.any || strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, m := range p.matchers {
+		if m(u) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkOrigin rejects requests from origins the policy doesn't allow with a
+// 403 response. Allowed cross origin HTTP requests get the CORS headers
+// browsers need, preflight requests are answered directly. Origins are only
+// allowed to send credentials when the policy names them, a policy allowing
+// any origin answers with a literal *.
+func checkOrigin(policy *OriginPolicy, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if !policy.Allowed(r) {
+			log.Printf("rejected request from origin %q to %s", origin, r.URL.Path)
+			transport.SendErrorf(w, http.StatusForbidden, "origin not allowed")
+			return
+		}
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h := w.Header()
+		if policy.any {
+			h.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			h.Add("Vary", "Origin")
+			h.Set("Access-Control-Allow-Origin", origin)
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			h.Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+			h.Set("Access-Control-Allow-Headers", "Authorization, Content-Type, X-GraphQL-Event-Stream-Token")
+			h.Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
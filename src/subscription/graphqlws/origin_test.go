@@ -0,0 +1,179 @@
+package graphqlws
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestOriginPolicyEntries(t *testing.T) {
+	p, err := ParseOriginPolicy([]string{
+		"app.example.com",
+		"https://admin.example.com:8443",
+		"https://*.trusted.io",
+		` regex:http://localhost:\d+ `,
+		"",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		origin  string
+		allowed bool
+	}{
+		{"", true},
+		{"http://app.example.com", true},
+		{"https://APP.example.com", true},
+		{"https://app.example.com:8080", false},
+		{"https://admin.example.com:8443", true},
+		{"http://admin.example.com:8443", false},
+		{"https://admin.example.com", false},
+		{"https://a.trusted.io", true},
+		{"https://a.b.trusted.io", true},
+		{"http://a.trusted.io", false},
+		{"https://trusted.io", false},
+		{"https://eviltrusted.io", false},
+		{"http://localhost:3000", true},
+		{"http://localhost:3000.evil.net", false},
+		{"https://evil.com/http://localhost:3000", false},
+		{"https://evil.com", false},
+		{"null", false},
+		// same origin
+		{"http://graphql.test", true},
+	} {
+		r := httptest.NewRequest(http.MethodPost, "http://graphql.test/graphql", nil)
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		if got := p.Allowed(r); got != tt.allowed {
+			t.Errorf("origin %q: allowed %t, want %t", tt.origin, got, tt.allowed)
+		}
+	}
+
+	for _, invalid := range []string{"regex:(", "https://", "example.com/path", "example.com?q"} {
+		if _, err := ParseOriginPolicy([]string{invalid}); err == nil {
+			t.Errorf("accepted %q", invalid)
+		}
+	}
+}
+
+func TestRegexOriginsMatchTheWholeOrigin(t *testing.T) {
+	p, err := ParseOriginPolicy([]string{`regex:https://app\.example\.com`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for origin, allowed := range map[string]bool{
+		"https://app.example.com":                  true,
+		"https://app.example.com.evil.net":         false,
+		"https://evil.net?https://app.example.com": false,
+		"http://https://app.example.com":           false,
+	} {
+		r := httptest.NewRequest(http.MethodGet, "http://graphql.test/graphql", nil)
+		r.Header.Set("Origin", origin)
+		if got := p.Allowed(r); got != allowed {
+			t.Errorf("origin %q: allowed %t, want %t", origin, got, allowed)
+		}
+	}
+}
+
+// serveOrigin sends a request from origin through checkOrigin
+func serveOrigin(policy *OriginPolicy, method string, origin string) (*httptest.ResponseRecorder, bool) {
+	served := false
+	h := checkOrigin(policy, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served = true
+	}))
+	r := httptest.NewRequest(method, "http://graphql.test/graphql", nil)
+	if origin != "" {
+		r.Header.Set("Origin", origin)
+	}
+	if method == http.MethodOptions {
+		r.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w, served
+}
+
+func TestCheckOrigin(t *testing.T) {
+	p, err := ParseOriginPolicy([]string{"https://app.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, served := serveOrigin(p, http.MethodPost, "https://evil.com")
+	if served || w.Code != http.StatusForbidden {
+		t.Errorf("disallowed origin: served %t with status %d, want 403", served, w.Code)
+	}
+
+	w, served = serveOrigin(p, http.MethodPost, "https://app.example.com")
+	if !served {
+		t.Fatal("allowed origin not served")
+	}
+	h := w.Header()
+	if h.Get("Access-Control-Allow-Origin") != "https://app.example.com" || h.Get("Access-Control-Allow-Credentials") != "true" || h.Get("Vary") != "Origin" {
+		t.Errorf("CORS headers %v", h)
+	}
+
+	// preflight requests are answered without reaching the handler
+	w, served = serveOrigin(p, http.MethodOptions, "https://app.example.com")
+	if served || w.Code != http.StatusNoContent {
+		t.Errorf("preflight: served %t with status %d, want 204", served, w.Code)
+	}
+	if h := w.Header(); h.Get("Access-Control-Allow-Methods") == "" || !strings.Contains(h.Get("Access-Control-Allow-Headers"), "Authorization") {
+		t.Errorf("preflight headers %v", h)
+	}
+	w, served = serveOrigin(p, http.MethodOptions, "https://evil.com")
+	if served || w.Code != http.StatusForbidden {
+		t.Errorf("disallowed preflight: served %t with status %d, want 403", served, w.Code)
+	}
+
+	// requests without an origin don't get CORS headers
+	w, served = serveOrigin(p, http.MethodPost, "")
+	if !served || w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("request without origin: served %t with headers %v", served, w.Header())
+	}
+}
+
+func TestAnyOriginIsNotCredentialed(t *testing.T) {
+	p, err := ParseOriginPolicy([]string{"*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, method := range []string{http.MethodPost, http.MethodOptions} {
+		w, _ := serveOrigin(p, method, "https://evil.com")
+		h := w.Header()
+		if h.Get("Access-Control-Allow-Origin") != "*" || h.Get("Access-Control-Allow-Credentials") != "" {
+			t.Errorf("%s: CORS headers %v, want * without credentials", method, h)
+		}
+	}
+}
+
+func TestWebsocketOriginsAreChecked(t *testing.T) {
+	p, err := ParseOriginPolicy([]string{"https://app.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, _ := newServer(t, WithOriginPolicy(p))
+
+	for origin, status := range map[string]int{
+		"https://app.example.com": http.StatusSwitchingProtocols,
+		"https://evil.com":        http.StatusForbidden,
+	} {
+		d := websocket.Dialer{Subprotocols: []string{"graphql-transport-ws"}}
+		c, res, err := d.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), http.Header{"Origin": {origin}})
+		if c != nil {
+			_ = c.Close()
+		}
+		if res == nil {
+			t.Fatalf("origin %s: %s", origin, err)
+		}
+		if res.StatusCode != status {
+			t.Errorf("origin %s: status %d, want %d", origin, res.StatusCode, status)
+		}
+	}
+}
@@ -1,9 +1,12 @@
 package transport
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/vektah/gqlparser/v2/gqlerror"
 )
@@ -31,7 +34,63 @@ func SendErrorf(w http.ResponseWriter, code int, format string, args ...interfac
 }
 
 func toGQLError(err error) *gqlerror.Error {
+	var gqlErr *gqlerror.Error
+	if errors.As(err, &gqlErr) {
+		return gqlErr
+	}
 	return &gqlerror.Error{
 		Message: err.Error(),
 	}
 }
+
+// startWindow is how long a subscription that failed to start takes at most to
+// send its errors. graphql-go sends them right after Subscribe returns, from
+// another goroutine.
+const startWindow = 50 * time.Millisecond
+
+// startErrors returns the errors of an operation that failed before it
+// started, such as on validation errors or when the subscription resolver
+// returned an error: its first result has errors but no data. HTTP transports
+// call it before they pick the response status. It waits for the result of
+// queries and mutations and up to startWindow for the first result of
+// subscriptions. The payloads returned are the ones left to read, including
+// the result received, if any.
+func startErrors(ctx context.Context, payloads <-chan interface{}, single bool) (gqlerror.List, <-chan interface{}) {
+	var started <-chan time.Time
+	if !single {
+		timer := time.NewTimer(startWindow)
+		defer timer.Stop()
+		started = timer.C
+	}
+
+	var payload interface{}
+	select {
+	case p, more := <-payloads:
+		if !more {
+			return nil, payloads
+		}
+		payload = p
+	case <-started:
+		return nil, payloads
+	case <-ctx.Done():
+		return nil, payloads
+	}
+
+	b, err := jsonEncode(payload)
+	if err == nil {
+		var result gqlResponse
+		if jsonDecode(b, &result) == nil && result.Data == nil && len(result.Errors) != 0 {
+			return result.Errors, payloads
+		}
+	}
+
+	rest := make(chan interface{}, 1)
+	rest <- payload
+	go func() {
+		defer close(rest)
+		for payload := range payloads {
+			rest <- payload
+		}
+	}()
+	return nil, rest
+}
@@ -0,0 +1,296 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+const longPollTransport = "long-poll"
+
+type (
+	// LongPoll serves GraphQL operations to clients that can neither keep a
+	// websocket nor a streaming response open. Requests carry the
+	// transport=long-poll query parameter:
+	//
+	//	POST   subscribes, the response holds the subscription token, or
+	//	       the errors of operations that fail before they start
+	//	GET    token=T&ack=N acknowledges the payloads up to N and waits for
+	//	       the following ones
+	//	DELETE token=T unsubscribes
+	//
+	// Payloads are buffered until they are acknowledged, subscriptions that
+	// aren't polled for IdleTimeout are stopped.
+	LongPoll struct {
+		// PollTimeout is how long a poll waits for payloads before it returns
+		// empty
+		PollTimeout time.Duration
+		// IdleTimeout stops subscriptions that aren't polled in time, 0 keeps
+		// them until they complete
+		IdleTimeout time.Duration
+		// BufferSize is the number of unacknowledged payloads kept per
+		// subscription, the oldest ones are dropped beyond it. 0 doesn't
+		// limit the buffer.
+		BufferSize int
+		Registry   *Registry
+
+		mu   sync.Mutex
+		subs map[string]*longPollSubscription
+	}
+
+	longPollSubscription struct {
+		token  string
+		cancel context.CancelFunc
+		idle   *time.Timer
+
+		mu      sync.Mutex
+		seq     int
+		buffer  []longPollPayload
+		missed  int
+		done    bool
+		updated chan struct{}
+	}
+
+	longPollPayload struct {
+		Seq     int             `json:"seq"`
+		Payload json.RawMessage `json:"payload"`
+	}
+
+	longPollResponse struct {
+		Payloads []longPollPayload `json:"payloads"`
+		// Missed counts the payloads dropped from the buffer since the
+		// previous poll
+		Missed int `json:"missed"`
+		// Complete is set once the operation is over, the subscription is
+		// gone after the last payloads are acknowledged
+		Complete bool `json:"complete"`
+	}
+)
+
+func (t *LongPoll) Supports(r *http.Request) bool {
+	return r.URL.Query().Get("transport") == longPollTransport
+}
+
+func (t *LongPoll) Do(w http.ResponseWriter, r *http.Request, service GraphQLService) {
+	switch r.Method {
+	case http.MethodPost:
+		if t.Registry != nil && t.Registry.isDraining() {
+			SendErrorf(w, http.StatusServiceUnavailable, "server shutting down")
+			return
+		}
+		t.subscribe(w, r, service)
+	case http.MethodGet:
+		t.poll(w, r)
+	case http.MethodDelete:
+		t.unsubscribe(w, r)
+	default:
+		SendErrorf(w, http.StatusMethodNotAllowed, "method %s not allowed", r.Method)
+	}
+}
+
+func (t *LongPoll) subscribe(w http.ResponseWriter, r *http.Request, service GraphQLService) {
+	params, err := decodeRequestParams(r)
+	if err != nil {
+		SendErrorf(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+	single, err := isSingleResult(params.Query, params.OperationName)
+	if err != nil {
+		SendError(w, http.StatusBadRequest, toGQLError(err))
+		return
+	}
+
+	token := uuid.NewString()
+	ctx, cancel := t.Registry.untilShutdown(withOperation(detach(r), token, ""))
+	ctx = withSubscriptionErrorContext(ctx)
+	untrack := t.Registry.track(singleOperationStream(token, r, longPollTransport, cancel))
+	payloads, err := service.Subscribe(ctx, params.Query, params.OperationName, params.Variables)
+	if err != nil {
+		untrack()
+		cancel()
+		SendError(w, http.StatusBadRequest, toGQLError(err))
+		return
+	}
+	var errs gqlerror.List
+	if errs, payloads = startErrors(ctx, payloads, single); len(errs) != 0 {
+		untrack()
+		cancel()
+		for range payloads { // drain input channel
+		}
+		SendError(w, http.StatusBadRequest, errs...)
+		return
+	}
+
+	s := &longPollSubscription{
+		token:   token,
+		cancel:  cancel,
+		updated: make(chan struct{}),
+	}
+	if t.IdleTimeout != 0 {
+		s.idle = time.AfterFunc(t.IdleTimeout, func() { t.remove(s) })
+	}
+
+	t.mu.Lock()
+	if t.subs == nil {
+		t.subs = map[string]*longPollSubscription{}
+	}
+	t.subs[token] = s
+	t.mu.Unlock()
+
+	go func() {
+		defer func() {
+			untrack()
+			s.complete()
+			cancel()
+			for range payloads { // drain input channel
+			}
+		}()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case payload, more := <-payloads:
+				if !more {
+					return
+				}
+				b, err := jsonEncode(payload)
+				if err != nil {
+					log.Printf("unable to encode long-poll payload: %s", err)
+					continue
+				}
+				s.push(b, t.BufferSize)
+				if single {
+					return
+				}
+			}
+		}
+	}()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	b, _ := jsonEncode(map[string]string{"token": token})
+	_, _ = w.Write(b)
+}
+
+func (t *LongPoll) get(token string) *longPollSubscription {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.subs[token]
+}
+
+// remove forgets the subscription and stops its operation
+func (t *LongPoll) remove(s *longPollSubscription) {
+	t.mu.Lock()
+	delete(t.subs, s.token)
+	t.mu.Unlock()
+	if s.idle != nil {
+		s.idle.Stop()
+	}
+	s.cancel()
+}
+
+func (t *LongPoll) poll(w http.ResponseWriter, r *http.Request) {
+	s := t.get(r.URL.Query().Get("token"))
+	if s == nil {
+		SendErrorf(w, http.StatusNotFound, "subscription not found")
+		return
+	}
+	ack, _ := strconv.Atoi(r.URL.Query().Get("ack"))
+
+	// a poll in progress keeps the subscription alive
+	if s.idle != nil {
+		s.idle.Stop()
+		defer s.idle.Reset(t.IdleTimeout)
+	}
+
+	res, updated := s.acknowledge(ack)
+	if len(res.Payloads) == 0 && !res.Complete {
+		timeout := time.NewTimer(t.PollTimeout)
+		defer timeout.Stop()
+		select {
+		case <-updated:
+			missed := res.Missed
+			res, _ = s.acknowledge(ack)
+			res.Missed += missed
+		case <-timeout.C:
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	if res.Complete && len(res.Payloads) == 0 {
+		t.remove(s)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	b, err := jsonEncode(res)
+	if err != nil {
+		panic(err)
+	}
+	_, _ = w.Write(b)
+}
+
+func (t *LongPoll) unsubscribe(w http.ResponseWriter, r *http.Request) {
+	s := t.get(r.URL.Query().Get("token"))
+	if s == nil {
+		SendErrorf(w, http.StatusNotFound, "subscription not found")
+		return
+	}
+	t.remove(s)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *longPollSubscription) push(payload json.RawMessage, size int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seq++
+	s.buffer = append(s.buffer, longPollPayload{Seq: s.seq, Payload: payload})
+	if size > 0 && len(s.buffer) > size {
+		s.missed += len(s.buffer) - size
+		s.buffer = s.buffer[len(s.buffer)-size:]
+	}
+	s.notify()
+}
+
+func (s *longPollSubscription) complete() {
+	s.mu.Lock()
+	s.done = true
+	s.notify()
+	s.mu.Unlock()
+}
+
+// notify wakes up waiting polls, s.mu must be held
+func (s *longPollSubscription) notify() {
+	close(s.updated)
+	s.updated = make(chan struct{})
+}
+
+// acknowledge drops the payloads up to ack and returns the remaining ones,
+// along with a channel closed on the next update
+func (s *longPollSubscription) acknowledge(ack int) (longPollResponse, <-chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := 0
+	for i < len(s.buffer) && s.buffer[i].Seq <= ack {
+		i++
+	}
+	s.buffer = s.buffer[i:]
+
+	res := longPollResponse{
+		Payloads: append([]longPollPayload{}, s.buffer...),
+		Missed:   s.missed,
+		Complete: s.done,
+	}
+	s.missed = 0
+	return res, s.updated
+}
@@ -0,0 +1,239 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// feedService streams the payloads fed by the test to its subscription
+type feedService struct {
+	feed    chan interface{}
+	stopped chan struct{}
+}
+
+func newFeedService() *feedService {
+	return &feedService{feed: make(chan interface{}), stopped: make(chan struct{})}
+}
+
+func (s *feedService) Subscribe(ctx context.Context, document string, operationName string, variableValues map[string]interface{}) (<-chan interface{}, error) {
+	payloads := make(chan interface{})
+	go func() {
+		defer close(s.stopped)
+		defer close(payloads)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case payload := <-s.feed:
+				payloads <- payload
+			}
+		}
+	}()
+	return payloads, nil
+}
+
+func (s *feedService) send(t *testing.T, tick int) {
+	t.Helper()
+
+	select {
+	case s.feed <- map[string]interface{}{"data": map[string]interface{}{"tick": tick}}:
+	case <-time.After(2 * time.Second):
+		t.Fatal("payload not read")
+	}
+}
+
+func (s *feedService) waitStopped(t *testing.T) {
+	t.Helper()
+
+	select {
+	case <-s.stopped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("subscription still running")
+	}
+}
+
+// newLongPollServer serves every request with lp until the test ends
+func newLongPollServer(t *testing.T, lp *LongPoll, service GraphQLService) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lp.Do(w, r, service)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// longPollSubscribe subscribes to operationName and returns the subscription
+// token
+func longPollSubscribe(t *testing.T, srv *httptest.Server, document string, operationName string) string {
+	t.Helper()
+
+	res := do(t, newRequest(t, http.MethodPost, srv.URL+"?transport=long-poll", `{"query": "`+document+`", "operationName": "`+operationName+`"}`))
+	var subscribed struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&subscribed); err != nil || res.StatusCode != http.StatusCreated || subscribed.Token == "" {
+		t.Fatalf("subscribe: status %d with token %q: %v", res.StatusCode, subscribed.Token, err)
+	}
+	return subscribed.Token
+}
+
+// longPoll polls the subscription token after it acknowledged the payloads up
+// to ack, it returns nil when the subscription is unknown
+func longPoll(t *testing.T, srv *httptest.Server, token string, ack int) *longPollResponse {
+	t.Helper()
+
+	res := do(t, newRequest(t, http.MethodGet, srv.URL+"?transport=long-poll&token="+token+"&ack="+strconv.Itoa(ack), ""))
+	if res.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	var poll longPollResponse
+	if err := json.NewDecoder(res.Body).Decode(&poll); err != nil || res.StatusCode != http.StatusOK {
+		t.Fatalf("poll: status %d: %v", res.StatusCode, err)
+	}
+	return &poll
+}
+
+// seqs returns the seq of the payloads of poll
+func seqs(poll *longPollResponse) []int {
+	seqs := []int{}
+	for _, p := range poll.Payloads {
+		seqs = append(seqs, p.Seq)
+	}
+	return seqs
+}
+
+func TestLongPollSubscriptions(t *testing.T) {
+	service := newFeedService()
+	srv := newLongPollServer(t, &LongPoll{PollTimeout: 20 * time.Millisecond, BufferSize: 2}, service)
+	token := longPollSubscribe(t, srv, "subscription Tick { tick }", "Tick")
+
+	// polls return empty once they time out
+	if poll := longPoll(t, srv, token, 0); poll == nil || len(poll.Payloads) != 0 || poll.Complete {
+		t.Fatalf("got %+v, want an empty poll", poll)
+	}
+
+	// payloads beyond the buffer size are dropped, oldest first
+	for tick := 1; tick <= 3; tick++ {
+		service.send(t, tick)
+	}
+	var poll *longPollResponse
+	for deadline := time.Now().Add(2 * time.Second); ; {
+		if poll = longPoll(t, srv, token, 0); len(poll.Payloads) == 2 || time.Now().After(deadline) {
+			break
+		}
+	}
+	if got := seqs(poll); len(got) != 2 || got[0] != 2 || got[1] != 3 || poll.Missed != 1 {
+		t.Fatalf("got seqs %v with %d missed, want [2 3] with 1 missed", got, poll.Missed)
+	}
+
+	// unacknowledged payloads are polled again
+	if got := seqs(longPoll(t, srv, token, 2)); len(got) != 1 || got[0] != 3 {
+		t.Fatalf("got seqs %v, want [3]", got)
+	}
+
+	if res := do(t, newRequest(t, http.MethodDelete, srv.URL+"?transport=long-poll&token="+token, "")); res.StatusCode != http.StatusOK {
+		t.Fatalf("unsubscribe: status %d", res.StatusCode)
+	}
+	service.waitStopped(t)
+	if poll := longPoll(t, srv, token, 3); poll != nil {
+		t.Fatalf("got %+v after unsubscribing", poll)
+	}
+}
+
+func TestLongPollWaitsForPayloads(t *testing.T) {
+	service := newFeedService()
+	srv := newLongPollServer(t, &LongPoll{PollTimeout: 10 * time.Second}, service)
+	token := longPollSubscribe(t, srv, "subscription Tick { tick }", "Tick")
+
+	polled := make(chan *longPollResponse, 1)
+	go func() {
+		res, err := http.Get(srv.URL + "?transport=long-poll&token=" + token)
+		if err != nil {
+			t.Error(err)
+			polled <- nil
+			return
+		}
+		defer res.Body.Close()
+		var poll longPollResponse
+		if err := json.NewDecoder(res.Body).Decode(&poll); err != nil {
+			t.Error(err)
+		}
+		polled <- &poll
+	}()
+	service.send(t, 1)
+
+	select {
+	case poll := <-polled:
+		if got := seqs(poll); len(got) != 1 || got[0] != 1 {
+			t.Fatalf("got seqs %v, want [1]", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("poll still waiting")
+	}
+}
+
+func TestLongPollSingleResults(t *testing.T) {
+	srv := newLongPollServer(t, &LongPoll{PollTimeout: 10 * time.Second}, &testService{})
+	token := longPollSubscribe(t, srv, "query Q { tick }", "Q")
+
+	poll := longPoll(t, srv, token, 0)
+	if got := seqs(poll); len(got) != 1 || !poll.Complete {
+		t.Fatalf("got %+v, want the result of a complete operation", poll)
+	}
+	var result gqlResponse
+	if err := json.Unmarshal(poll.Payloads[0].Payload, &result); err != nil || string(result.Data) != `{"tick":1}` {
+		t.Fatalf("got result %s: %v", poll.Payloads[0].Payload, err)
+	}
+
+	// the subscription is gone once the last payload is acknowledged
+	if poll := longPoll(t, srv, token, 1); poll == nil || len(poll.Payloads) != 0 || !poll.Complete {
+		t.Fatalf("got %+v, want a complete empty poll", poll)
+	}
+	if poll := longPoll(t, srv, token, 1); poll != nil {
+		t.Fatalf("got %+v, want the subscription to be gone", poll)
+	}
+}
+
+func TestLongPollIdleSubscriptionsStop(t *testing.T) {
+	service := newFeedService()
+	srv := newLongPollServer(t, &LongPoll{PollTimeout: time.Second, IdleTimeout: 20 * time.Millisecond}, service)
+	token := longPollSubscribe(t, srv, "subscription Tick { tick }", "Tick")
+
+	service.waitStopped(t)
+	if poll := longPoll(t, srv, token, 0); poll != nil {
+		t.Fatalf("got %+v, want the subscription to be gone", poll)
+	}
+}
+
+func TestLongPollRejectsOperationsThatCannotStart(t *testing.T) {
+	lp := &LongPoll{PollTimeout: time.Second}
+	srv := newLongPollServer(t, lp, &testService{})
+
+	for _, op := range [][2]string{
+		{"query {", ""},
+		{"subscription Fail { tick }", "Fail"},
+		{"subscription Invalid { tick }", "Invalid"},
+		{"query Invalid { tick }", "Invalid"},
+	} {
+		res := do(t, newRequest(t, http.MethodPost, srv.URL+"?transport=long-poll", `{"query": "`+op[0]+`", "operationName": "`+op[1]+`"}`))
+		var body gqlResponse
+		if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusBadRequest || len(body.Errors) == 0 {
+			t.Errorf("%s: status %d with %+v, want 400 with errors", op[0], res.StatusCode, body)
+		}
+	}
+
+	lp.mu.Lock()
+	defer lp.mu.Unlock()
+	if len(lp.subs) != 0 {
+		t.Errorf("%d subscriptions kept", len(lp.subs))
+	}
+}
@@ -0,0 +1,159 @@
+package transport
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+// https://www.apollographql.com/docs/router/executing-operations/subscription-multipart-protocol
+const (
+	multipartContentType = "multipart/mixed"
+	multipartBoundary    = "graphql"
+)
+
+type (
+	// Multipart serves GraphQL operations over multipart/mixed HTTP responses,
+	// every result is a part of the response. Queries and mutations get a
+	// single part holding their result. Subscriptions follow the Apollo
+	// multipart subscription protocol: results are wrapped in a payload
+	// member, errors ending the subscription are sent in a top-level errors
+	// member and empty heartbeat parts keep idle responses open. Operations
+	// that fail before they start get an error response instead.
+	Multipart struct {
+		// HeartbeatInterval sends an empty part on idle responses, 0 disables
+		// heartbeats
+		HeartbeatInterval time.Duration
+		Registry          *Registry
+	}
+
+	multipartPart struct {
+		Payload *gqlResponse  `json:"payload"`
+		Errors  gqlerror.List `json:"errors,omitempty"`
+	}
+)
+
+func (t Multipart) Supports(r *http.Request) bool {
+	return (r.Method == http.MethodGet || r.Method == http.MethodPost) &&
+		strings.Contains(r.Header.Get("Accept"), multipartContentType)
+}
+
+func (t Multipart) Do(w http.ResponseWriter, r *http.Request, service GraphQLService) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		SendErrorf(w, http.StatusInternalServerError, "streaming unsupported")
+		return
+	}
+
+	if t.Registry != nil && t.Registry.isDraining() {
+		SendErrorf(w, http.StatusServiceUnavailable, "server shutting down")
+		return
+	}
+
+	params, err := decodeRequestParams(r)
+	if err != nil {
+		SendErrorf(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+	single, err := isSingleResult(params.Query, params.OperationName)
+	if err != nil {
+		SendError(w, http.StatusBadRequest, toGQLError(err))
+		return
+	}
+
+	id := uuid.NewString()
+	ctx, cancel := t.Registry.untilShutdown(withOperation(r.Context(), id, ""))
+	defer cancel()
+	defer t.Registry.track(singleOperationStream(id, r, "multipart", cancel))()
+
+	ctx = withSubscriptionErrorContext(ctx)
+	payloads, err := service.Subscribe(ctx, params.Query, params.OperationName, params.Variables)
+	if err != nil {
+		SendError(w, http.StatusBadRequest, toGQLError(err))
+		return
+	}
+	defer func() {
+		cancel()
+		for range payloads { // drain input channel
+		}
+	}()
+	var errs gqlerror.List
+	if errs, payloads = startErrors(ctx, payloads, single); len(errs) != 0 {
+		SendError(w, http.StatusBadRequest, errs...)
+		return
+	}
+
+	contentType := fmt.Sprintf(`%s; boundary="%s"`, multipartContentType, multipartBoundary)
+	if !single {
+		contentType += `; subscriptionSpec="1.0"`
+	}
+	h := w.Header()
+	h.Set("Content-Type", contentType)
+	h.Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	_, _ = io.WriteString(w, "\r\n--"+multipartBoundary)
+	flusher.Flush()
+
+	var heartbeat <-chan time.Time
+	if t.HeartbeatInterval != 0 && !single {
+		ticker := time.NewTicker(t.HeartbeatInterval)
+		defer ticker.Stop()
+		heartbeat = ticker.C
+	}
+
+	defer func() {
+		if errs := getSubscriptionError(ctx); len(errs) != 0 {
+			writeMultipartPart(w, multipartPart{Errors: errs})
+		}
+		_, _ = io.WriteString(w, "--\r\n")
+		flusher.Flush()
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-heartbeat:
+			_, _ = io.WriteString(w, "\r\nContent-Type: application/json; charset=utf-8\r\n\r\n{}\r\n--"+multipartBoundary)
+			flusher.Flush()
+		case payload, more := <-payloads:
+			if !more {
+				return
+			}
+			b, err := jsonEncode(payload)
+			if err != nil {
+				log.Printf("unable to encode multipart payload: %s", err)
+				continue
+			}
+			if single {
+				writeMultipartPart(w, json.RawMessage(b))
+				flusher.Flush()
+				return
+			}
+			var response gqlResponse
+			if err := jsonDecode(b, &response); err != nil {
+				log.Printf("unable to decode multipart payload: %s", err)
+				continue
+			}
+			writeMultipartPart(w, multipartPart{Payload: &response})
+			flusher.Flush()
+		}
+	}
+}
+
+// writeMultipartPart writes the json encoding of part, a result or a
+// multipartPart
+func writeMultipartPart(w io.Writer, part interface{}) {
+	b, err := jsonEncode(part)
+	if err != nil {
+		panic(err)
+	}
+	_, _ = fmt.Fprintf(w, "\r\nContent-Type: application/json; charset=utf-8\r\n\r\n%s\r\n--%s", b, multipartBoundary)
+}
@@ -0,0 +1,163 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+// newMultipartServer serves every request with m until the test ends
+func newMultipartServer(t *testing.T, m Multipart, service GraphQLService) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m.Do(w, r, service)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// postMultipart sends an operation accepting a multipart response
+func postMultipart(t *testing.T, srv *httptest.Server, document string, operationName string) *http.Response {
+	t.Helper()
+
+	req := newRequest(t, http.MethodPost, srv.URL, `{"query": "`+document+`", "operationName": "`+operationName+`"}`)
+	req.Header.Set("Accept", `multipart/mixed; subscriptionSpec="1.0", application/json`)
+	return do(t, req)
+}
+
+// readParts returns a reader of the parts of res, after it checked the
+// response is multipart and whether it is a subscription's
+func readParts(t *testing.T, res *http.Response, subscription bool) *multipart.Reader {
+	t.Helper()
+
+	mediaType, params, err := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	if res.StatusCode != http.StatusOK || err != nil || mediaType != multipartContentType {
+		t.Fatalf("status %d with content type %q, want a multipart response", res.StatusCode, res.Header.Get("Content-Type"))
+	}
+	if _, ok := params["subscriptionspec"]; ok != subscription {
+		t.Fatalf("content type %q, subscription %t", res.Header.Get("Content-Type"), subscription)
+	}
+	return multipart.NewReader(res.Body, params["boundary"])
+}
+
+// nextPart returns the json body of the next part
+func nextPart(t *testing.T, parts *multipart.Reader) string {
+	t.Helper()
+
+	p, err := parts.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func expectNoMorePart(t *testing.T, parts *multipart.Reader) {
+	t.Helper()
+
+	if p, err := parts.NextPart(); err != io.EOF {
+		b, _ := io.ReadAll(p)
+		t.Fatalf("got part %s, want the end of the response: %v", b, err)
+	}
+}
+
+func TestMultipartSingleResults(t *testing.T) {
+	srv := newMultipartServer(t, Multipart{HeartbeatInterval: time.Millisecond}, &testService{})
+
+	// the result isn't wrapped and no heartbeat is sent
+	parts := readParts(t, postMultipart(t, srv, "query Q { tick }", "Q"), false)
+	if part := nextPart(t, parts); part != `{"data":{"tick":1}}` {
+		t.Fatalf("got part %s, want the plain result", part)
+	}
+	expectNoMorePart(t, parts)
+}
+
+func TestMultipartSubscriptions(t *testing.T) {
+	service := &testService{}
+	srv := newMultipartServer(t, Multipart{HeartbeatInterval: 10 * time.Millisecond}, service)
+
+	parts := readParts(t, postMultipart(t, srv, "subscription Finite { tick }", "Finite"), true)
+	if part := nextPart(t, parts); part != `{"payload":{"data":{"tick":1}}}` {
+		t.Fatalf("got part %s, want the wrapped result", part)
+	}
+	expectNoMorePart(t, parts)
+
+	// idle responses get heartbeats until the client goes away
+	req := newRequest(t, http.MethodPost, srv.URL, `{"query": "subscription Tick { tick }", "operationName": "Tick"}`)
+	req.Header.Set("Accept", "multipart/mixed")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts = readParts(t, res, true)
+	nextPart(t, parts)
+	if part := nextPart(t, parts); part != "{}" {
+		t.Fatalf("got part %s, want a heartbeat", part)
+	}
+	service.waitRunning(t, 1)
+	_ = res.Body.Close()
+	service.waitRunning(t, 0)
+}
+
+// erroringService ends subscriptions with an error after their first result
+type erroringService struct{}
+
+func (erroringService) Subscribe(ctx context.Context, document string, operationName string, variableValues map[string]interface{}) (<-chan interface{}, error) {
+	payloads := make(chan interface{})
+	go func() {
+		defer close(payloads)
+		payloads <- map[string]interface{}{"data": map[string]interface{}{"tick": 1}}
+		AddSubscriptionError(ctx, &gqlerror.Error{Message: "upstream gone"})
+	}()
+	return payloads, nil
+}
+
+func TestMultipartErrors(t *testing.T) {
+	srv := newMultipartServer(t, Multipart{}, &testService{})
+
+	// operations that can't start get an error response
+	for _, op := range [][2]string{
+		{"query {", ""},
+		{"subscription Fail { tick }", "Fail"},
+		{"subscription Invalid { tick }", "Invalid"},
+		{"query Invalid { tick }", "Invalid"},
+	} {
+		res := postMultipart(t, srv, op[0], op[1])
+		var body gqlResponse
+		if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != http.StatusBadRequest || len(body.Errors) == 0 {
+			t.Errorf("%s: status %d with %+v, want 400 with errors", op[0], res.StatusCode, body)
+		}
+	}
+
+	// errors ending a running subscription are sent at the top level
+	srv = newMultipartServer(t, Multipart{}, erroringService{})
+	parts := readParts(t, postMultipart(t, srv, "subscription Tick { tick }", "Tick"), true)
+	nextPart(t, parts)
+	var part struct {
+		Payload *gqlResponse  `json:"payload"`
+		Errors  gqlerror.List `json:"errors"`
+	}
+	if err := json.Unmarshal([]byte(nextPart(t, parts)), &part); err != nil {
+		t.Fatal(err)
+	}
+	if part.Payload != nil || len(part.Errors) != 1 || !strings.Contains(part.Errors[0].Message, "upstream gone") {
+		t.Fatalf("got part %+v, want a top-level error", part)
+	}
+	expectNoMorePart(t, parts)
+}
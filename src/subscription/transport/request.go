@@ -0,0 +1,46 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// requestParams are the parameters of a GraphQL over HTTP request
+type requestParams struct {
+	startMessagePayload
+	Extensions map[string]interface{} `json:"extensions"`
+}
+
+// decodeRequestParams reads the parameters of GET requests from the query
+// string and those of other requests from the json body
+func decodeRequestParams(r *http.Request) (requestParams, error) {
+	var params requestParams
+	if r.Method == http.MethodGet {
+		q := r.URL.Query()
+		params.Query = q.Get("query")
+		params.OperationName = q.Get("operationName")
+		if v := q.Get("variables"); v != "" {
+			if err := jsonDecode([]byte(v), &params.Variables); err != nil {
+				return params, fmt.Errorf("invalid variables")
+			}
+		}
+		if v := q.Get("extensions"); v != "" {
+			if err := jsonDecode([]byte(v), &params.Extensions); err != nil {
+				return params, fmt.Errorf("invalid extensions")
+			}
+		}
+		return params, nil
+	}
+
+	if err := jsonDecodeReader(r.Body, &params); err != nil {
+		return params, fmt.Errorf("invalid json body")
+	}
+	return params, nil
+}
+
+// detach returns the context of an operation outliving the request r, it keeps
+// the values of r, such as the authenticated principal
+func detach(r *http.Request) context.Context {
+	return context.WithoutCancel(r.Context())
+}
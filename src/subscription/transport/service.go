@@ -2,7 +2,9 @@ package transport
 
 import "context"
 
-// GraphQLService interface
+// GraphQLService interface. Subscribe resolves every kind of operation, the
+// payloads are GraphQL responses with data, errors and extensions members.
+// Queries and mutations send a single payload.
 type GraphQLService interface {
 	Subscribe(ctx context.Context, document string, operationName string, variableValues map[string]interface{}) (payloads <-chan interface{}, err error)
 }
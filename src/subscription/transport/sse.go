@@ -0,0 +1,464 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+// https://github.com/enisdenjo/graphql-sse/blob/master/PROTOCOL.md
+const (
+	sseContentType = "text/event-stream"
+	sseTokenHeader = "X-GraphQL-Event-Stream-Token"
+
+	sseNextEvent     = "next"
+	sseCompleteEvent = "complete"
+)
+
+type (
+	// SSE serves GraphQL operations over Server-Sent Events following the
+	// graphql-sse protocol. In distinct connections mode every operation gets
+	// its own event stream. In single connection mode the client reserves a
+	// stream with PUT, listens to it with GET, starts operations with POST and
+	// stops them with DELETE, all identified by the stream token.
+	SSE struct {
+		// KeepAliveInterval sends a comment on idle streams so proxies keep
+		// them open, 0 disables it
+		KeepAliveInterval time.Duration
+		// ReservationTimeout releases reserved streams the client didn't
+		// listen to in time, 0 keeps them forever
+		ReservationTimeout time.Duration
+		Registry           *Registry
+
+		mu      sync.Mutex
+		streams map[string]*sseStream
+	}
+
+	sseStream struct {
+		token  string
+		ctx    context.Context
+		cancel context.CancelFunc
+		events chan sseEvent
+
+		// untrack removes the stream from the registry
+		untrack func()
+
+		mu        sync.Mutex
+		connected bool
+		active    map[string]context.CancelFunc
+	}
+
+	sseEvent struct {
+		event string
+		data  []byte
+	}
+)
+
+// Supports matches stream reservations, PUT requests accepting text/plain, the
+// requests carrying the stream token header and those accepting
+// text/event-stream. The token query parameter alone isn't enough, other
+// transports use it too.
+func (t *SSE) Supports(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	if r.Method == http.MethodPut {
+		return strings.Contains(accept, "text/plain")
+	}
+	return r.Header.Get(sseTokenHeader) != "" || strings.Contains(accept, sseContentType)
+}
+
+func (t *SSE) Do(w http.ResponseWriter, r *http.Request, service GraphQLService) {
+	// stopping operations is still allowed
+	if t.Registry != nil && t.Registry.isDraining() && r.Method != http.MethodDelete {
+		SendErrorf(w, http.StatusServiceUnavailable, "server shutting down")
+		return
+	}
+
+	token := streamToken(r)
+	switch {
+	case r.Method == http.MethodPut:
+		t.reserve(w, r)
+	case token == "" && (r.Method == http.MethodGet || r.Method == http.MethodPost):
+		t.distinct(w, r, service)
+	case token == "":
+		SendErrorf(w, http.StatusMethodNotAllowed, "method %s not allowed", r.Method)
+	case r.Method == http.MethodGet:
+		t.listen(w, r, token)
+	case r.Method == http.MethodPost:
+		t.execute(w, r, token, service)
+	case r.Method == http.MethodDelete:
+		t.stop(w, r, token)
+	default:
+		SendErrorf(w, http.StatusMethodNotAllowed, "method %s not allowed", r.Method)
+	}
+}
+
+func streamToken(r *http.Request) string {
+	if token := r.Header.Get(sseTokenHeader); token != "" {
+		return token
+	}
+	return r.URL.Query().Get("token")
+}
+
+// distinct streams the results of a single operation on the response
+func (t *SSE) distinct(w http.ResponseWriter, r *http.Request, service GraphQLService) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		SendErrorf(w, http.StatusInternalServerError, "streaming unsupported")
+		return
+	}
+
+	params, err := decodeRequestParams(r)
+	if err != nil {
+		SendErrorf(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+	single, err := isSingleResult(params.Query, params.OperationName)
+	if err != nil {
+		SendError(w, http.StatusBadRequest, toGQLError(err))
+		return
+	}
+
+	id := uuid.NewString()
+	ctx, cancel := t.Registry.untilShutdown(withOperation(r.Context(), id, ""))
+	defer cancel()
+	defer t.Registry.track(singleOperationStream(id, r, "sse", cancel))()
+
+	payloads, err := service.Subscribe(withSubscriptionErrorContext(ctx), params.Query, params.OperationName, params.Variables)
+	if err != nil {
+		SendError(w, http.StatusBadRequest, toGQLError(err))
+		return
+	}
+	defer func() {
+		cancel()
+		for range payloads { // drain input channel
+		}
+	}()
+	var errs gqlerror.List
+	if errs, payloads = startErrors(ctx, payloads, single); len(errs) != 0 {
+		SendError(w, http.StatusBadRequest, errs...)
+		return
+	}
+
+	writeSSEHeaders(w)
+	flusher.Flush()
+
+	var keepAlive <-chan time.Time
+	if t.KeepAliveInterval != 0 {
+		ticker := time.NewTicker(t.KeepAliveInterval)
+		defer ticker.Stop()
+		keepAlive = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-keepAlive:
+			_, _ = io.WriteString(w, ":\n\n")
+			flusher.Flush()
+		case payload, more := <-payloads:
+			if !more {
+				writeSSEEvent(w, sseEvent{event: sseCompleteEvent})
+				flusher.Flush()
+				return
+			}
+			b, err := jsonEncode(payload)
+			if err != nil {
+				log.Printf("unable to encode sse payload: %s", err)
+				continue
+			}
+			writeSSEEvent(w, sseEvent{event: sseNextEvent, data: b})
+			if single {
+				writeSSEEvent(w, sseEvent{event: sseCompleteEvent})
+				flusher.Flush()
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// reserve creates a single connection mode stream, the response body is its
+// token
+func (t *SSE) reserve(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := t.Registry.untilShutdown(context.Background())
+	s := &sseStream{
+		token:  uuid.NewString(),
+		ctx:    ctx,
+		cancel: cancel,
+		events: make(chan sseEvent, 64),
+		active: map[string]context.CancelFunc{},
+	}
+
+	s.untrack = t.Registry.track(&httpStream{
+		id:            s.token,
+		remoteAddr:    r.RemoteAddr,
+		transport:     "sse",
+		startedAt:     time.Now(),
+		operations:    s.operationIDs,
+		stopOperation: s.stopOperation,
+		close:         func() { t.release(s) },
+	})
+
+	t.mu.Lock()
+	if t.streams == nil {
+		t.streams = map[string]*sseStream{}
+	}
+	t.streams[s.token] = s
+	t.mu.Unlock()
+
+	if t.ReservationTimeout != 0 {
+		time.AfterFunc(t.ReservationTimeout, func() {
+			s.mu.Lock()
+			connected := s.connected
+			s.mu.Unlock()
+			if !connected {
+				t.release(s)
+			}
+		})
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusCreated)
+	_, _ = io.WriteString(w, s.token)
+}
+
+func (t *SSE) get(token string) *sseStream {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.streams[token]
+}
+
+// release forgets the stream and stops its operations
+func (t *SSE) release(s *sseStream) {
+	t.mu.Lock()
+	delete(t.streams, s.token)
+	t.mu.Unlock()
+	s.untrack()
+	s.cancel()
+}
+
+// listen writes the events of a single connection mode stream until the
+// client goes away
+func (t *SSE) listen(w http.ResponseWriter, r *http.Request, token string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		SendErrorf(w, http.StatusInternalServerError, "streaming unsupported")
+		return
+	}
+
+	s := t.get(token)
+	if s == nil {
+		SendErrorf(w, http.StatusNotFound, "stream not found")
+		return
+	}
+	s.mu.Lock()
+	connected := s.connected
+	s.connected = true
+	s.mu.Unlock()
+	if connected {
+		SendErrorf(w, http.StatusConflict, "stream already open")
+		return
+	}
+	defer t.release(s)
+
+	writeSSEHeaders(w)
+	flusher.Flush()
+
+	var keepAlive <-chan time.Time
+	if t.KeepAliveInterval != 0 {
+		ticker := time.NewTicker(t.KeepAliveInterval)
+		defer ticker.Stop()
+		keepAlive = ticker.C
+	}
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.ctx.Done():
+			return
+		case <-keepAlive:
+			_, _ = io.WriteString(w, ":\n\n")
+			flusher.Flush()
+		case e := <-s.events:
+			writeSSEEvent(w, e)
+			flusher.Flush()
+		}
+	}
+}
+
+// execute starts an operation on a single connection mode stream, its results
+// are sent on the stream
+func (t *SSE) execute(w http.ResponseWriter, r *http.Request, token string, service GraphQLService) {
+	s := t.get(token)
+	if s == nil {
+		SendErrorf(w, http.StatusNotFound, "stream not found")
+		return
+	}
+
+	params, err := decodeRequestParams(r)
+	if err != nil {
+		SendErrorf(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+	id, _ := params.Extensions["operationId"].(string)
+	if id == "" {
+		SendErrorf(w, http.StatusBadRequest, "operationId extension is required")
+		return
+	}
+	single, err := isSingleResult(params.Query, params.OperationName)
+	if err != nil {
+		SendError(w, http.StatusBadRequest, toGQLError(err))
+		return
+	}
+
+	ctx, cancel := context.WithCancel(withOperation(detach(r), s.token, id))
+	stop := context.AfterFunc(s.ctx, cancel)
+
+	s.mu.Lock()
+	if _, exists := s.active[id]; exists {
+		s.mu.Unlock()
+		stop()
+		cancel()
+		SendErrorf(w, http.StatusConflict, "operation %s already exists", id)
+		return
+	}
+	s.active[id] = cancel
+	s.mu.Unlock()
+
+	ctx = withSubscriptionErrorContext(ctx)
+	payloads, err := service.Subscribe(ctx, params.Query, params.OperationName, params.Variables)
+	if err != nil {
+		s.finish(id)
+		stop()
+		cancel()
+		SendError(w, http.StatusBadRequest, toGQLError(err))
+		return
+	}
+	var errs gqlerror.List
+	if errs, payloads = startErrors(ctx, payloads, single); len(errs) != 0 {
+		s.finish(id)
+		stop()
+		cancel()
+		for range payloads { // drain input channel
+		}
+		SendError(w, http.StatusBadRequest, errs...)
+		return
+	}
+
+	go func() {
+		defer func() {
+			stop()
+			cancel()
+			for range payloads { // drain input channel
+			}
+		}()
+		defer s.finish(id)
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case payload, more := <-payloads:
+				if !more {
+					s.send(ctx, sseCompleteEvent, id, nil)
+					return
+				}
+				b, err := jsonEncode(payload)
+				if err != nil {
+					log.Printf("unable to encode sse payload: %s", err)
+					continue
+				}
+				s.send(ctx, sseNextEvent, id, b)
+				if single {
+					s.send(ctx, sseCompleteEvent, id, nil)
+					return
+				}
+			}
+		}
+	}()
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// stop stops an operation of a single connection mode stream
+func (t *SSE) stop(w http.ResponseWriter, r *http.Request, token string) {
+	s := t.get(token)
+	if s == nil {
+		SendErrorf(w, http.StatusNotFound, "stream not found")
+		return
+	}
+
+	s.stopOperation(r.URL.Query().Get("operationId"))
+	w.WriteHeader(http.StatusOK)
+}
+
+// stopOperation stops the operation id, it returns false when it is unknown
+func (s *sseStream) stopOperation(id string) bool {
+	s.mu.Lock()
+	cancel := s.active[id]
+	s.mu.Unlock()
+	if cancel == nil {
+		return false
+	}
+	cancel()
+	return true
+}
+
+func (s *sseStream) operationIDs() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := make([]string, 0, len(s.active))
+	for id := range s.active {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func (s *sseStream) finish(id string) {
+	s.mu.Lock()
+	delete(s.active, id)
+	s.mu.Unlock()
+}
+
+// send queues an event of the operation id on the stream, payload is omitted
+// when nil
+func (s *sseStream) send(ctx context.Context, event string, id string, payload json.RawMessage) {
+	b, err := jsonEncode(struct {
+		ID      string          `json:"id"`
+		Payload json.RawMessage `json:"payload,omitempty"`
+	}{ID: id, Payload: payload})
+	if err != nil {
+		panic(err)
+	}
+
+	select {
+	case s.events <- sseEvent{event: event, data: b}:
+	case <-ctx.Done():
+	}
+}
+
+func writeSSEHeaders(w http.ResponseWriter) {
+	h := w.Header()
+	h.Set("Content-Type", sseContentType+"; charset=utf-8")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Connection", "keep-alive")
+	// keep nginx from buffering the stream
+	h.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+}
+
+func writeSSEEvent(w io.Writer, e sseEvent) {
+	_, _ = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.event, e.data)
+}
@@ -0,0 +1,256 @@
+package transport
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newSSEServer serves every request with sse until the test ends
+func newSSEServer(t *testing.T, sse *SSE, service GraphQLService) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sse.Do(w, r, service)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// readEvents reads the events of an SSE response in the background, comments
+// are skipped
+func readEvents(t *testing.T, res *http.Response) <-chan sseEvent {
+	t.Helper()
+
+	if res.StatusCode != http.StatusOK || !strings.HasPrefix(res.Header.Get("Content-Type"), sseContentType) {
+		t.Fatalf("status %d with content type %q, want an event stream", res.StatusCode, res.Header.Get("Content-Type"))
+	}
+	events := make(chan sseEvent, 16)
+	go func() {
+		defer close(events)
+		var e sseEvent
+		sc := bufio.NewScanner(res.Body)
+		for sc.Scan() {
+			line := sc.Text()
+			switch {
+			case line == "" && e.event != "":
+				events <- e
+				e = sseEvent{}
+			case strings.HasPrefix(line, "event: "):
+				e.event = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: "):
+				e.data = []byte(strings.TrimPrefix(line, "data: "))
+			}
+		}
+	}()
+	return events
+}
+
+// expectEvent reads the next event and checks its type, its data is decoded
+// into v unless nil
+func expectEvent(t *testing.T, events <-chan sseEvent, event string, v interface{}) {
+	t.Helper()
+
+	select {
+	case e, ok := <-events:
+		if !ok {
+			t.Fatalf("expected %s, the stream ended", event)
+		}
+		if e.event != event {
+			t.Fatalf("expected %s, got %s %s", event, e.event, e.data)
+		}
+		if v != nil {
+			if err := json.Unmarshal(e.data, v); err != nil {
+				t.Fatal(err)
+			}
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("expected %s, got nothing", event)
+	}
+}
+
+// expectErrors checks the response status and the message of its first error
+func expectErrors(t *testing.T, res *http.Response, status int, message string) {
+	t.Helper()
+
+	var body gqlResponse
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != status || len(body.Errors) == 0 || !strings.Contains(body.Errors[0].Message, message) {
+		t.Fatalf("status %d with %+v, want %d with error %q", res.StatusCode, body, status, message)
+	}
+}
+
+func TestSSEDistinctConnections(t *testing.T) {
+	service := &testService{}
+	srv := newSSEServer(t, &SSE{}, service)
+	operation := func(method string, document string, operationName string) *http.Request {
+		var req *http.Request
+		if method == http.MethodGet {
+			req = newRequest(t, method, srv.URL+"?query="+strings.ReplaceAll(document, " ", "+")+"&operationName="+operationName, "")
+		} else {
+			req = newRequest(t, method, srv.URL, `{"query": "`+document+`", "operationName": "`+operationName+`"}`)
+		}
+		req.Header.Set("Accept", "text/event-stream")
+		return req
+	}
+
+	// queries complete after their result
+	events := readEvents(t, do(t, operation(http.MethodGet, "query Q { tick }", "Q")))
+	var result gqlResponse
+	expectEvent(t, events, "next", &result)
+	if string(result.Data) != `{"tick":1}` {
+		t.Fatalf("result %+v", result)
+	}
+	expectEvent(t, events, "complete", nil)
+
+	// finite subscriptions complete once they are over
+	events = readEvents(t, do(t, operation(http.MethodPost, "subscription Finite { tick }", "Finite")))
+	expectEvent(t, events, "next", nil)
+	expectEvent(t, events, "complete", nil)
+
+	// subscriptions stop when the client goes away
+	res, err := http.DefaultClient.Do(operation(http.MethodPost, "subscription Tick { tick }", "Tick"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(t, readEvents(t, res), "next", nil)
+	service.waitRunning(t, 1)
+	_ = res.Body.Close()
+	service.waitRunning(t, 0)
+
+	// operations that can't start get an error response instead of a stream
+	expectErrors(t, do(t, operation(http.MethodPost, "query {", "")), http.StatusBadRequest, "Expected Name")
+	expectErrors(t, do(t, operation(http.MethodPost, "subscription Fail { tick }", "Fail")), http.StatusBadRequest, "subscribe failed")
+	expectErrors(t, do(t, operation(http.MethodPost, "subscription Invalid { tick }", "Invalid")), http.StatusBadRequest, "invalid operation")
+	expectErrors(t, do(t, operation(http.MethodPost, "query Invalid { tick }", "Invalid")), http.StatusBadRequest, "invalid operation")
+}
+
+// reserveStream reserves a single connection mode stream and returns its token
+func reserveStream(t *testing.T, srv *httptest.Server) string {
+	t.Helper()
+
+	req := newRequest(t, http.MethodPut, srv.URL, "")
+	req.Header.Set("Accept", "text/plain")
+	res := do(t, req)
+	token, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != http.StatusCreated || len(token) == 0 {
+		t.Fatalf("reservation: status %d with token %q", res.StatusCode, token)
+	}
+	return string(token)
+}
+
+// streamRequest is a request on the single connection mode stream token
+func streamRequest(t *testing.T, method string, target string, token string, body string) *http.Request {
+	t.Helper()
+
+	req := newRequest(t, method, target, body)
+	req.Header.Set(sseTokenHeader, token)
+	if method == http.MethodGet {
+		req.Header.Set("Accept", "text/event-stream")
+	}
+	return req
+}
+
+func TestSSESingleConnection(t *testing.T) {
+	service := &testService{}
+	srv := newSSEServer(t, &SSE{}, service)
+	token := reserveStream(t, srv)
+	execute := func(id string, document string, operationName string) *http.Response {
+		t.Helper()
+		return do(t, streamRequest(t, http.MethodPost, srv.URL, token, `{"query": "`+document+`", "operationName": "`+operationName+`", "extensions": {"operationId": "`+id+`"}}`))
+	}
+	type operationEvent struct {
+		ID      string       `json:"id"`
+		Payload *gqlResponse `json:"payload"`
+	}
+	expectOperationEvent := func(events <-chan sseEvent, event string, id string) {
+		t.Helper()
+		var e operationEvent
+		expectEvent(t, events, event, &e)
+		if e.ID != id || (event == "next") != (e.Payload != nil) {
+			t.Fatalf("%s event %+v, want one of operation %s", event, e, id)
+		}
+	}
+
+	events := readEvents(t, do(t, streamRequest(t, http.MethodGet, srv.URL, token, "")))
+	if res := do(t, streamRequest(t, http.MethodGet, srv.URL, token, "")); res.StatusCode != http.StatusConflict {
+		t.Errorf("second listener: status %d, want 409", res.StatusCode)
+	}
+
+	if res := execute("q", "query Q { tick }", "Q"); res.StatusCode != http.StatusAccepted {
+		t.Fatalf("query: status %d", res.StatusCode)
+	}
+	expectOperationEvent(events, "next", "q")
+	expectOperationEvent(events, "complete", "q")
+
+	if res := execute("s", "subscription Tick { tick }", "Tick"); res.StatusCode != http.StatusAccepted {
+		t.Fatalf("subscription: status %d", res.StatusCode)
+	}
+	expectOperationEvent(events, "next", "s")
+	if res := execute("s", "subscription Tick { tick }", "Tick"); res.StatusCode != http.StatusConflict {
+		t.Errorf("duplicate operation: status %d, want 409", res.StatusCode)
+	}
+
+	// operations that can't start are rejected by the request starting them
+	expectErrors(t, execute("f", "subscription Fail { tick }", "Fail"), http.StatusBadRequest, "subscribe failed")
+	expectErrors(t, execute("i", "subscription Invalid { tick }", "Invalid"), http.StatusBadRequest, "invalid operation")
+	expectErrors(t, execute("", "query Q { tick }", "Q"), http.StatusBadRequest, "operationId")
+
+	if res := do(t, streamRequest(t, http.MethodDelete, srv.URL+"?operationId=s", token, "")); res.StatusCode != http.StatusOK {
+		t.Fatalf("stop: status %d", res.StatusCode)
+	}
+	service.waitRunning(t, 0)
+
+	// the ids of rejected and stopped operations can be reused
+	if res := execute("s", "query Q { tick }", "Q"); res.StatusCode != http.StatusAccepted {
+		t.Fatalf("query: status %d", res.StatusCode)
+	}
+	expectOperationEvent(events, "next", "s")
+	expectOperationEvent(events, "complete", "s")
+
+	for _, method := range []string{http.MethodGet, http.MethodPost, http.MethodDelete} {
+		if res := do(t, streamRequest(t, method, srv.URL, "unknown", "")); res.StatusCode != http.StatusNotFound {
+			t.Errorf("%s of an unknown stream: status %d, want 404", method, res.StatusCode)
+		}
+	}
+}
+
+func TestSSESupports(t *testing.T) {
+	for _, tt := range []struct {
+		method   string
+		target   string
+		header   http.Header
+		supports bool
+	}{
+		{http.MethodPut, "/graphql", http.Header{"Accept": {"text/plain"}}, true},
+		{http.MethodPut, "/graphql", nil, false},
+		{http.MethodPut, "/graphql", http.Header{"Accept": {"application/json"}}, false},
+		{http.MethodGet, "/graphql?query=%7Btick%7D", http.Header{"Accept": {"text/event-stream"}}, true},
+		{http.MethodPost, "/graphql", http.Header{"Accept": {"text/event-stream"}}, true},
+		{http.MethodPost, "/graphql", http.Header{"Accept": {"application/json"}}, false},
+		{http.MethodPost, "/graphql", http.Header{sseTokenHeader: {"t"}, "Accept": {"application/json"}}, true},
+		{http.MethodDelete, "/graphql?operationId=1", http.Header{sseTokenHeader: {"t"}}, true},
+		{http.MethodGet, "/graphql?token=t", http.Header{"Accept": {"text/event-stream"}}, true},
+		// other transports use a token parameter
+		{http.MethodGet, "/graphql?token=t", http.Header{"Accept": {"application/json"}}, false},
+		{http.MethodDelete, "/graphql?token=t", nil, false},
+	} {
+		req := httptest.NewRequest(tt.method, tt.target, nil)
+		for k, v := range tt.header {
+			req.Header.Set(k, v[0])
+		}
+		if got := (&SSE{}).Supports(req); got != tt.supports {
+			t.Errorf("%s %s %v: supported %t, want %t", tt.method, tt.target, tt.header, got, tt.supports)
+		}
+	}
+}
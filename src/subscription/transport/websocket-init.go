@@ -5,9 +5,15 @@ import "context"
 type key string
 
 const (
-	initpayload key = "ws_initpayload_context"
+	initpayload    key = "ws_initpayload_context"
+	connectioninfo key = "ws_connectioninfo_context"
 )
 
+type connectionInfo struct {
+	remoteAddr  string
+	subprotocol string
+}
+
 // InitPayload is a structure that is parsed from the websocket init message payload. TO use
 // request headers for non-websocket, instead wrap the graphql handler in a middleware.
 type InitPayload map[string]interface{}
@@ -55,3 +61,22 @@ func GetInitPayload(ctx context.Context) InitPayload {
 
 	return payload
 }
+
+func withConnectionInfo(ctx context.Context, remoteAddr string, subprotocol string) context.Context {
+	return context.WithValue(ctx, connectioninfo, connectionInfo{remoteAddr: remoteAddr, subprotocol: subprotocol})
+}
+
+// GetRemoteAddr gets the network address of the websocket client, it returns an
+// empty string outside of a websocket connection.
+func GetRemoteAddr(ctx context.Context) string {
+	info, _ := ctx.Value(connectioninfo).(connectionInfo)
+	return info.remoteAddr
+}
+
+// GetSubprotocol gets the subprotocol negotiated by the websocket connection,
+// it returns an empty string outside of a websocket connection or when the
+// client didn't ask for one.
+func GetSubprotocol(ctx context.Context) string {
+	info, _ := ctx.Value(connectioninfo).(connectionInfo)
+	return info.subprotocol
+}
@@ -11,8 +11,11 @@ import (
 	"sync"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
+	"github.com/vektah/gqlparser/v2/ast"
 	"github.com/vektah/gqlparser/v2/gqlerror"
+	"github.com/vektah/gqlparser/v2/parser"
 )
 
 type (
@@ -23,21 +26,26 @@ type (
 		ErrorFunc             WebsocketErrorFunc
 		KeepAlivePingInterval time.Duration
 		PingPongInterval      time.Duration
+		Registry              *Registry
 
 		didInjectSubprotocols bool
 	}
 	wsConnection struct {
 		Websocket
+		id              string
+		startedAt       time.Time
 		ctx             context.Context
 		conn            *websocket.Conn
 		me              messageExchanger
-		active          map[string]context.CancelFunc
+		active          map[string]*operation
 		mu              sync.Mutex
 		keepAliveTicker *time.Ticker
 		pingPongTicker  *time.Ticker
 		service         GraphQLService
 
 		initPayload InitPayload
+		// draining connections refuse new operations
+		draining bool
 	}
 
 	WebsocketInitFunc  func(ctx context.Context, initPayload InitPayload) (context.Context, error)
@@ -73,6 +81,11 @@ func (t Websocket) Supports(r *http.Request) bool {
 }
 
 func (t Websocket) Do(w http.ResponseWriter, r *http.Request, service GraphQLService) {
+	if t.Registry != nil && t.Registry.isDraining() {
+		SendErrorf(w, http.StatusServiceUnavailable, "server shutting down")
+		return
+	}
+
 	t.injectGraphQLWSSubprotocols()
 	ws, err := t.Upgrader.Upgrade(w, r, http.Header{})
 	if err != nil {
@@ -96,14 +109,25 @@ func (t Websocket) Do(w http.ResponseWriter, r *http.Request, service GraphQLSer
 	}
 
 	conn := wsConnection{
-		active:    map[string]context.CancelFunc{},
+		id:        uuid.NewString(),
+		startedAt: time.Now(),
+		active:    map[string]*operation{},
 		conn:      ws,
-		ctx:       r.Context(),
+		ctx:       withConnectionInfo(r.Context(), r.RemoteAddr, ws.Subprotocol()),
 		service:   service,
 		me:        me,
 		Websocket: t,
 	}
 
+	if t.Registry != nil {
+		// upgrades racing with Shutdown are closed right away
+		if !t.Registry.add(&conn) {
+			conn.close(websocket.CloseGoingAway, "server shutting down")
+			return
+		}
+		defer t.Registry.remove(&conn)
+	}
+
 	if !conn.init() {
 		return
 	}
@@ -145,20 +169,33 @@ func (c *wsConnection) init() bool {
 	var m message
 	var err error
 
-	if c.InitTimeout != 0 {
-		m, err = c.nextMessageWithTimeout(c.InitTimeout)
-	} else {
-		m, err = c.me.NextMessage()
+	deadline := time.Now().Add(c.InitTimeout)
+	for {
+		if c.InitTimeout != 0 {
+			m, err = c.nextMessageWithTimeout(time.Until(deadline))
+		} else {
+			m, err = c.me.NextMessage()
+		}
+
+		// pings may be sent before the connection is acknowledged
+		if err != nil || (m.t != pingMessageType && m.t != pongMessageType) {
+			break
+		}
+		if m.t == pingMessageType {
+			c.write(&message{t: pongMessageType, payload: m.payload})
+		}
 	}
 
 	if err != nil {
 		if err == errReadTimeout {
-			c.close(websocket.CloseProtocolError, "connection initialisation timeout")
+			c.closeProtocolViolation(closeInitTimeout, websocket.CloseProtocolError, "connection initialisation timeout")
 			return false
 		}
 
-		if err == errInvalidMsg {
+		if errors.Is(err, errInvalidMsg) {
 			c.sendConnectionError("invalid json")
+			c.closeProtocolViolation(closeBadRequest, websocket.CloseProtocolError, "decoding error")
+			return false
 		}
 
 		c.close(websocket.CloseProtocolError, "decoding error")
@@ -171,15 +208,22 @@ func (c *wsConnection) init() bool {
 			c.initPayload = make(InitPayload)
 			err := jsonDecode(m.payload, &c.initPayload)
 			if err != nil {
+				c.sendConnectionError("invalid json")
+				c.closeProtocolViolation(closeBadRequest, websocket.CloseProtocolError, "invalid init payload")
 				return false
 			}
 		}
+		// every operation of the connection derives its context from c.ctx,
+		// the payload is available to InitFunc and to resolvers alike
+		if c.initPayload != nil {
+			c.ctx = withInitPayload(c.ctx, c.initPayload)
+		}
 
 		if c.InitFunc != nil {
 			ctx, err := c.InitFunc(c.ctx, c.initPayload)
 			if err != nil {
 				c.sendConnectionError(err.Error())
-				c.close(websocket.CloseNormalClosure, "terminated")
+				c.closeProtocolViolation(closeForbidden, websocket.CloseNormalClosure, "terminated")
 				return false
 			}
 			c.ctx = ctx
@@ -192,7 +236,7 @@ func (c *wsConnection) init() bool {
 		return false
 	default:
 		c.sendConnectionError("unexpected message %s", m.t)
-		c.close(websocket.CloseProtocolError, "unexpected message")
+		c.closeProtocolViolation(closeUnauthorized, websocket.CloseProtocolError, "unexpected message")
 		return false
 	}
 
@@ -200,8 +244,16 @@ func (c *wsConnection) init() bool {
 }
 
 func (c *wsConnection) write(msg *message) {
+	c.writeOperation(nil, msg)
+}
+
+// writeOperation sends msg unless the client stopped op, the client doesn't
+// expect messages for it anymore and may have reused its id already
+func (c *wsConnection) writeOperation(op *operation, msg *message) {
 	c.mu.Lock()
-	c.handlePossibleError(c.me.Send(msg), false)
+	if op == nil || !op.stoppedByClient {
+		c.handlePossibleError(c.me.Send(msg), false)
+	}
 	c.mu.Unlock()
 }
 
@@ -241,8 +293,18 @@ func (c *wsConnection) run() {
 	// Will optionally send a "close reason" that is retrieved from the context.
 	go c.closeOnCancel(ctx)
 
+	// Close the connection when the credentials it authenticated with expire
+	if expiresAt := expiryForContext(c.ctx); !expiresAt.IsZero() {
+		go c.closeOnExpiry(ctx, expiresAt)
+	}
+
 	for {
 		m, err := c.me.NextMessage()
+		if errors.Is(err, errInvalidMsg) {
+			c.sendConnectionError("invalid message")
+			c.closeProtocolViolation(closeBadRequest, websocket.CloseProtocolError, "invalid message")
+			return
+		}
 		if err != nil {
 			// If the connection got closed by us, don't report the error
 			if !errors.Is(err, net.ErrClosed) {
@@ -252,15 +314,16 @@ func (c *wsConnection) run() {
 		}
 
 		switch m.t {
+		case initMessageType:
+			c.sendConnectionError("connection already initialised")
+			c.closeProtocolViolation(closeTooManyInitRequests, websocket.CloseProtocolError, "too many initialisation requests")
+			return
 		case startMessageType:
 			c.subscribe(c.ctx, &m)
 		case stopMessageType:
-			c.mu.Lock()
-			closer := c.active[m.id]
-			c.mu.Unlock()
-			if closer != nil {
-				closer()
-			}
+			// stopping an unknown or finished operation is a no-op, the
+			// client may not have seen its complete message yet
+			c.stopOperation(m.id, true)
 		case connectionCloseMessageType:
 			c.close(websocket.CloseNormalClosure, "terminated")
 			return
@@ -270,7 +333,7 @@ func (c *wsConnection) run() {
 			_ = c.conn.SetReadDeadline(time.Now().UTC().Add(2 * c.PingPongInterval))
 		default:
 			c.sendConnectionError("unexpected message %s", m.t)
-			c.close(websocket.CloseProtocolError, "unexpected message")
+			c.closeProtocolViolation(closeBadRequest, websocket.CloseProtocolError, "unexpected message")
 			return
 		}
 	}
@@ -310,48 +373,89 @@ func (c *wsConnection) closeOnCancel(ctx context.Context) {
 }
 
 func (c *wsConnection) subscribe(ctx context.Context, msg *message) {
+	if c.isTransportWS() && msg.id == "" {
+		c.closeProtocolViolation(closeBadRequest, websocket.CloseProtocolError, "missing operation id")
+		return
+	}
+
+	ctx, cancel := context.WithCancel(withOperation(ctx, c.id, msg.id))
+
+	op, err := c.startOperation(msg.id, cancel)
+	if errors.Is(err, errDraining) {
+		cancel()
+		c.sendError(msg.id, &gqlerror.Error{Message: "server shutting down"})
+		if !c.isTransportWS() {
+			c.complete(msg.id)
+		}
+		return
+	}
+	if err != nil {
+		cancel()
+		if c.isTransportWS() {
+			c.closeProtocolViolation(closeSubscriberAlreadyExists, websocket.CloseProtocolError, fmt.Sprintf("subscriber for %s already exists", msg.id))
+		} else {
+			c.sendError(msg.id, &gqlerror.Error{Message: fmt.Sprintf("subscriber for %s already exists", msg.id)})
+		}
+		return
+	}
+
 	var params startMessagePayload
 	if err := jsonDecode(msg.payload, &params); err != nil {
+		c.finishOperation(op)
+		if c.isTransportWS() {
+			c.closeProtocolViolation(closeBadRequest, websocket.CloseProtocolError, "invalid subscribe payload")
+			return
+		}
 		c.sendError(msg.id, &gqlerror.Error{Message: "invalid json"})
 		c.complete(msg.id)
 		return
 	}
 
-	ctx, cancel := context.WithCancel(ctx)
-
-	payloads, err := c.service.Subscribe(ctx, params.Query, params.OperationName, params.Variables)
+	// queries and mutations have a single result, malformed documents none
+	single, err := isSingleResult(params.Query, params.OperationName)
 	if err != nil {
-		c.sendError(msg.id, toGQLError(err))
-		c.complete(msg.id)
-		cancel()
+		c.rejectOperation(op, toGQLError(err))
 		return
 	}
 
-	if c.initPayload != nil {
-		ctx = withInitPayload(ctx, c.initPayload)
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("panic in websocket operation %s: %v", msg.id, r)
+			c.finishOperation(op)
+			c.closeProtocolViolation(closeInternalServerError, websocket.CloseInternalServerErr, "internal server error")
+		}
+	}()
+
+	ctx = withSubscriptionErrorContext(ctx)
+	payloads, err := c.service.Subscribe(ctx, params.Query, params.OperationName, params.Variables)
+	if err != nil {
+		c.rejectOperation(op, toGQLError(err))
+		return
 	}
 
-	c.mu.Lock()
-	c.active[msg.id] = cancel
-	c.mu.Unlock()
+	c.runOperation(op)
 
 	go func() {
-		ctx = withSubscriptionErrorContext(ctx)
+		rejected := false
+		defer func() {
+			for range payloads { // drain input channel
+			}
+		}()
 		defer func() {
+			if rejected {
+				return
+			}
+			// the id may be reused as soon as the client learns the
+			// operation is over
+			c.finishOperation(op)
 			if errs := getSubscriptionError(ctx); len(errs) != 0 {
-				c.sendError(msg.id, errs...)
+				c.writeOperation(op, errorMessage(op.id, errs...))
 			} else {
-				c.complete(msg.id)
-			}
-			c.mu.Lock()
-			delete(c.active, msg.id)
-			c.mu.Unlock()
-			cancel()
-			for range payloads { // drain input channel
+				c.writeOperation(op, &message{id: op.id, t: completeMessageType})
 			}
 		}()
 
-		for {
+		for first := true; ; first = false {
 			select {
 			case <-ctx.Done():
 				return
@@ -359,14 +463,25 @@ func (c *wsConnection) subscribe(ctx context.Context, msg *message) {
 				if !more {
 					return
 				}
-				jsonPayload, err := jsonEncode(payload)
+				response, err := jsonEncode(payload)
 				if err != nil {
-					c.sendError(msg.id, toGQLError(err))
+					c.writeOperation(op, errorMessage(op.id, toGQLError(err)))
 					continue
 				}
-				c.sendResponse(msg.id, gqlResponse{
-					Data: jsonPayload,
-				})
+
+				// a first result without data means the operation was never
+				// executed, e.g. it failed validation
+				var result gqlResponse
+				if first && jsonDecode(response, &result) == nil && result.Data == nil && len(result.Errors) != 0 {
+					rejected = true
+					c.rejectOperation(op, result.Errors...)
+					return
+				}
+
+				c.writeOperation(op, &message{payload: response, id: op.id, t: dataMessageType})
+				if single {
+					return
+				}
 			}
 		}
 
@@ -374,16 +489,28 @@ func (c *wsConnection) subscribe(ctx context.Context, msg *message) {
 	}()
 }
 
-func (c *wsConnection) sendResponse(id string, response gqlResponse) {
-	b, err := jsonEncode(response)
+// rejectOperation ends an operation that didn't execute with errors.
+// graphql-transport-ws operations end with their error message, graphql-ws
+// operations are completed as well.
+func (c *wsConnection) rejectOperation(op *operation, errs ...*gqlerror.Error) {
+	c.finishOperation(op)
+	c.writeOperation(op, errorMessage(op.id, errs...))
+	if !c.isTransportWS() {
+		c.writeOperation(op, &message{id: op.id, t: completeMessageType})
+	}
+}
+
+// isSingleResult reports whether the operation of document is a query or a
+// mutation, it returns the syntax error of malformed documents.
+func isSingleResult(document string, operationName string) (bool, error) {
+	doc, err := parser.ParseQuery(&ast.Source{Input: document})
 	if err != nil {
-		panic(err)
+		return false, err
 	}
-	c.write(&message{
-		payload: b,
-		id:      id,
-		t:       dataMessageType,
-	})
+
+	op := doc.Operations.ForName(operationName)
+	// unknown operations are left for the service to report
+	return op != nil && op.Operation != ast.Subscription, nil
 }
 
 func (c *wsConnection) complete(id string) {
@@ -391,6 +518,10 @@ func (c *wsConnection) complete(id string) {
 }
 
 func (c *wsConnection) sendError(id string, errors ...*gqlerror.Error) {
+	c.write(errorMessage(id, errors...))
+}
+
+func errorMessage(id string, errors ...*gqlerror.Error) *message {
 	errs := make([]error, len(errors))
 	for i, err := range errors {
 		errs[i] = err
@@ -399,7 +530,7 @@ func (c *wsConnection) sendError(id string, errors ...*gqlerror.Error) {
 	if err != nil {
 		panic(err)
 	}
-	c.write(&message{t: errorMessageType, id: id, payload: b})
+	return &message{t: errorMessageType, id: id, payload: b}
 }
 
 func (c *wsConnection) sendConnectionError(format string, args ...interface{}) {
@@ -414,8 +545,11 @@ func (c *wsConnection) sendConnectionError(format string, args ...interface{}) {
 func (c *wsConnection) close(closeCode int, message string) {
 	c.mu.Lock()
 	_ = c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(closeCode, message))
-	for _, closer := range c.active {
-		closer()
+	for _, op := range c.active {
+		if op.state < operationCompleting {
+			op.state = operationCompleting
+		}
+		op.cancel()
 	}
 	c.mu.Unlock()
 	_ = c.conn.Close()
@@ -0,0 +1,314 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	tickSubscription   = `subscription Tick { tick }`
+	finiteSubscription = `subscription Finite { tick }`
+	failSubscription   = `subscription Fail { tick }`
+	panicSubscription  = `subscription Panic { tick }`
+	helloQuery         = `query Hello { hello }`
+)
+
+// subprotocol is the vocabulary of a websocket subprotocol and the close codes
+// it uses for protocol violations
+type subprotocol struct {
+	name      string
+	subscribe string
+	next      string
+	stop      string
+	// rejected operations are completed after their error
+	completesErrors bool
+
+	badRequest              int
+	unauthorized            int
+	forbidden               int
+	initTimeout             int
+	tooManyInitRequests     int
+	internalServerError     int
+	subscriberAlreadyExists int
+}
+
+var subprotocols = []subprotocol{
+	{
+		name:      "graphql-transport-ws",
+		subscribe: "subscribe",
+		next:      "next",
+		stop:      "complete",
+
+		badRequest:              4400,
+		unauthorized:            4401,
+		forbidden:               4403,
+		initTimeout:             4408,
+		subscriberAlreadyExists: 4409,
+		tooManyInitRequests:     4429,
+		internalServerError:     4500,
+	},
+	{
+		name:            "graphql-ws",
+		subscribe:       "start",
+		next:            "data",
+		stop:            "stop",
+		completesErrors: true,
+
+		badRequest:          websocket.CloseProtocolError,
+		unauthorized:        websocket.CloseProtocolError,
+		forbidden:           websocket.CloseNormalClosure,
+		initTimeout:         websocket.CloseProtocolError,
+		tooManyInitRequests: websocket.CloseProtocolError,
+		internalServerError: websocket.CloseInternalServerErr,
+	},
+}
+
+// connectionError is sent before closing graphql-ws connections, it has no
+// graphql-transport-ws equivalent
+func (p subprotocol) connectionError() []string {
+	if p.name == "graphql-ws" {
+		return []string{"connection_error"}
+	}
+	return nil
+}
+
+// probe checks the connection is still open and nothing was sent since the
+// previous message: the result of a query must come next
+func (p subprotocol) probe(c *testClient) {
+	c.t.Helper()
+
+	c.operation(p.subscribe, "probe", helloQuery, "Hello")
+	c.expect(p.next, "probe")
+	c.expect("complete", "probe")
+}
+
+func forEachSubprotocol(t *testing.T, test func(t *testing.T, p subprotocol)) {
+	for _, p := range subprotocols {
+		p := p
+		t.Run(p.name, func(t *testing.T) {
+			test(t, p)
+		})
+	}
+}
+
+func TestConformanceOperationBeforeInit(t *testing.T) {
+	forEachSubprotocol(t, func(t *testing.T, p subprotocol) {
+		c := dialTest(t, newTestServer(t, Websocket{}, &testService{}), p.name)
+
+		c.operation(p.subscribe, "1", tickSubscription, "Tick")
+		c.expectClose(p.unauthorized, p.connectionError()...)
+	})
+}
+
+func TestConformanceInitTwice(t *testing.T) {
+	forEachSubprotocol(t, func(t *testing.T, p subprotocol) {
+		c := dialTest(t, newTestServer(t, Websocket{}, &testService{}), p.name)
+		c.init()
+
+		c.send("connection_init", "", nil)
+		c.expectClose(p.tooManyInitRequests, p.connectionError()...)
+	})
+}
+
+func TestConformanceInitTimeout(t *testing.T) {
+	forEachSubprotocol(t, func(t *testing.T, p subprotocol) {
+		c := dialTest(t, newTestServer(t, Websocket{InitTimeout: 50 * time.Millisecond}, &testService{}), p.name)
+
+		c.expectClose(p.initTimeout)
+	})
+}
+
+func TestConformanceInitRejected(t *testing.T) {
+	forEachSubprotocol(t, func(t *testing.T, p subprotocol) {
+		ws := Websocket{InitFunc: func(ctx context.Context, initPayload InitPayload) (context.Context, error) {
+			return nil, errors.New("invalid credentials")
+		}}
+		c := dialTest(t, newTestServer(t, ws, &testService{}), p.name)
+
+		c.send("connection_init", "", map[string]interface{}{"Authorization": "nope"})
+		c.expectClose(p.forbidden, p.connectionError()...)
+	})
+}
+
+func TestConformanceInvalidJSON(t *testing.T) {
+	forEachSubprotocol(t, func(t *testing.T, p subprotocol) {
+		srv := newTestServer(t, Websocket{}, &testService{})
+
+		c := dialTest(t, srv, p.name)
+		c.sendRaw(`{nope`)
+		c.expectClose(p.badRequest, p.connectionError()...)
+
+		c = dialTest(t, srv, p.name)
+		c.init()
+		c.sendRaw(`{nope`)
+		c.expectClose(p.badRequest, p.connectionError()...)
+	})
+}
+
+func TestConformanceTransportWSUnexpectedMessage(t *testing.T) {
+	c := dialTest(t, newTestServer(t, Websocket{}, &testService{}), "graphql-transport-ws")
+	c.init()
+
+	// next is a server to client message
+	c.send("next", "1", nil)
+	c.expectClose(4400)
+}
+
+func TestConformanceTransportWSMissingID(t *testing.T) {
+	c := dialTest(t, newTestServer(t, Websocket{}, &testService{}), "graphql-transport-ws")
+	c.init()
+
+	c.operation("subscribe", "", tickSubscription, "Tick")
+	c.expectClose(4400)
+}
+
+func TestConformanceDuplicateID(t *testing.T) {
+	forEachSubprotocol(t, func(t *testing.T, p subprotocol) {
+		service := &testService{}
+		c := dialTest(t, newTestServer(t, Websocket{}, service), p.name)
+		c.init()
+		c.operation(p.subscribe, "1", tickSubscription, "Tick")
+		c.expect(p.next, "1")
+
+		c.operation(p.subscribe, "1", tickSubscription, "Tick")
+		if p.subscriberAlreadyExists != 0 {
+			c.expectClose(p.subscriberAlreadyExists)
+			service.waitRunning(t, 0)
+			return
+		}
+		// graphql-ws has no close code for it, the operation is rejected and
+		// the first one keeps running
+		c.expect("error", "1")
+		p.probe(c)
+		if n := service.running.Load(); n != 1 {
+			t.Errorf("%d subscriptions running, want 1", n)
+		}
+	})
+}
+
+func TestConformanceSubscriptionLifecycle(t *testing.T) {
+	forEachSubprotocol(t, func(t *testing.T, p subprotocol) {
+		service := &testService{}
+		c := dialTest(t, newTestServer(t, Websocket{}, service), p.name)
+		c.init()
+
+		c.operation(p.subscribe, "1", tickSubscription, "Tick")
+		c.expect(p.next, "1")
+		c.send(p.stop, "1", nil)
+		service.waitRunning(t, 0)
+		// the client knows the operation is over, it isn't completed
+		p.probe(c)
+
+		// and its id can be reused right away
+		c.operation(p.subscribe, "1", tickSubscription, "Tick")
+		c.expect(p.next, "1")
+	})
+}
+
+func TestConformanceFiniteOperations(t *testing.T) {
+	forEachSubprotocol(t, func(t *testing.T, p subprotocol) {
+		c := dialTest(t, newTestServer(t, Websocket{}, &testService{}), p.name)
+		c.init()
+
+		c.operation(p.subscribe, "1", finiteSubscription, "Finite")
+		c.expect(p.next, "1")
+		c.expect("complete", "1")
+
+		c.operation(p.subscribe, "2", helloQuery, "Hello")
+		msg := c.expect(p.next, "2")
+		var result struct {
+			Data map[string]int `json:"data"`
+		}
+		payloadOf(t, msg, &result)
+		if result.Data["tick"] != 1 {
+			t.Errorf("got payload %v", msg["payload"])
+		}
+		c.expect("complete", "2")
+	})
+}
+
+func TestConformanceRejectedOperation(t *testing.T) {
+	forEachSubprotocol(t, func(t *testing.T, p subprotocol) {
+		c := dialTest(t, newTestServer(t, Websocket{}, &testService{}), p.name)
+		c.init()
+
+		c.operation(p.subscribe, "1", failSubscription, "Fail")
+		msg := c.expect("error", "1")
+		var errs []struct {
+			Message string `json:"message"`
+		}
+		payloadOf(t, msg, &errs)
+		if len(errs) != 1 || errs[0].Message != "subscribe failed" {
+			t.Errorf("got errors %v", msg["payload"])
+		}
+		if p.completesErrors {
+			c.expect("complete", "1")
+		}
+		// the connection stays open
+		p.probe(c)
+	})
+}
+
+func TestConformanceMalformedDocument(t *testing.T) {
+	forEachSubprotocol(t, func(t *testing.T, p subprotocol) {
+		c := dialTest(t, newTestServer(t, Websocket{}, &testService{}), p.name)
+		c.init()
+
+		c.operation(p.subscribe, "1", `subscription {`, "")
+		c.expect("error", "1")
+		if p.completesErrors {
+			c.expect("complete", "1")
+		}
+		p.probe(c)
+	})
+}
+
+func TestConformancePanickingOperation(t *testing.T) {
+	forEachSubprotocol(t, func(t *testing.T, p subprotocol) {
+		c := dialTest(t, newTestServer(t, Websocket{}, &testService{}), p.name)
+		c.init()
+
+		c.operation(p.subscribe, "1", panicSubscription, "Panic")
+		c.expectClose(p.internalServerError)
+	})
+}
+
+func TestConformanceStopUnknownOperation(t *testing.T) {
+	forEachSubprotocol(t, func(t *testing.T, p subprotocol) {
+		c := dialTest(t, newTestServer(t, Websocket{}, &testService{}), p.name)
+		c.init()
+
+		c.send(p.stop, "unknown", nil)
+		p.probe(c)
+	})
+}
+
+func TestConformanceTransportWSPing(t *testing.T) {
+	c := dialTest(t, newTestServer(t, Websocket{}, &testService{}), "graphql-transport-ws")
+
+	// pings are answered before the connection is acknowledged too
+	c.send("ping", "", map[string]interface{}{"n": 1})
+	msg := c.expect("pong", "")
+	var payload map[string]int
+	payloadOf(t, msg, &payload)
+	if payload["n"] != 1 {
+		t.Errorf("pong payload %v, want the ping one", msg["payload"])
+	}
+	c.init()
+}
+
+func TestConformanceGraphQLWSTerminate(t *testing.T) {
+	service := &testService{}
+	c := dialTest(t, newTestServer(t, Websocket{}, service), "graphql-ws")
+	c.init()
+	c.operation("start", "1", tickSubscription, "Tick")
+	c.expect("data", "1")
+
+	c.send("connection_terminate", "", nil)
+	c.expectClose(websocket.CloseNormalClosure)
+	service.waitRunning(t, 0)
+}
@@ -0,0 +1,43 @@
+package transport
+
+import (
+	"context"
+	"time"
+)
+
+// CloseCredentialsExpired is the close code sent when the credentials a
+// websocket connection authenticated with expire. Clients are expected to
+// reconnect with fresh credentials.
+const CloseCredentialsExpired = 4001
+
+// A private key for context that only this package can access. This is important
+// to prevent collisions between different context uses
+var expiryCtxKey = &wsExpiryContextKey{"expiry"}
+
+type wsExpiryContextKey struct {
+	name string
+}
+
+// WithExpiry marks the connection the context of an InitFunc belongs to as
+// valid until expiresAt. The connection is closed with CloseCredentialsExpired
+// once expiresAt passes, its active subscriptions end with it.
+func WithExpiry(ctx context.Context, expiresAt time.Time) context.Context {
+	return context.WithValue(ctx, expiryCtxKey, expiresAt)
+}
+
+func expiryForContext(ctx context.Context) time.Time {
+	expiresAt, _ := ctx.Value(expiryCtxKey).(time.Time)
+	return expiresAt
+}
+
+func (c *wsConnection) closeOnExpiry(ctx context.Context, expiresAt time.Time) {
+	timer := time.NewTimer(time.Until(expiresAt))
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+		c.sendConnectionError("credentials expired")
+		c.close(CloseCredentialsExpired, "credentials expired")
+	}
+}
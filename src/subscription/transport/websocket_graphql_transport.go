@@ -21,6 +21,17 @@ const (
 	graphqltransportwsPongMsg           = graphqltransportwsMessageType("pong")
 )
 
+// Close codes of https://github.com/enisdenjo/graphql-ws/blob/master/PROTOCOL.md
+const (
+	closeBadRequest              = 4400
+	closeUnauthorized            = 4401
+	closeForbidden               = 4403
+	closeInitTimeout             = 4408
+	closeSubscriberAlreadyExists = 4409
+	closeTooManyInitRequests     = 4429
+	closeInternalServerError     = 4500
+)
+
 var allGraphqltransportwsMessageTypes = []graphqltransportwsMessageType{
 	graphqltransportwsConnectionInitMsg,
 	graphqltransportwsConnectionAckMsg,
@@ -100,7 +111,7 @@ func (m graphqltransportwsMessage) toMessage() (message, error) {
 	var err error
 	switch m.Type {
 	default:
-		err = fmt.Errorf("invalid client->server message type %s", m.Type)
+		err = fmt.Errorf("%w: invalid client->server message type %s", errInvalidMsg, m.Type)
 	case graphqltransportwsConnectionInitMsg:
 		t = initMessageType
 	case graphqltransportwsSubscribeMsg:
@@ -147,3 +158,17 @@ func (m *graphqltransportwsMessage) fromMessage(msg *message) (err error) {
 
 	return err
 }
+
+func (c *wsConnection) isTransportWS() bool {
+	return c.conn.Subprotocol() == graphqltransportwsSubprotocol
+}
+
+// closeProtocolViolation closes the connection with the close code the
+// graphql-transport-ws protocol assigns to the violation. graphql-ws
+// connections keep getting graphqlwsCode.
+func (c *wsConnection) closeProtocolViolation(code int, graphqlwsCode int, reason string) {
+	if !c.isTransportWS() {
+		code = graphqlwsCode
+	}
+	c.close(code, reason)
+}
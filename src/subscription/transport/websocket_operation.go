@@ -0,0 +1,141 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// operationState is the lifecycle of an operation on a websocket connection.
+// States only move forward:
+//
+//	pending -> running -> completing -> done
+//	pending -> completing
+//
+// Only done frees the operation id for reuse, unless the client stopped the
+// operation itself.
+type operationState int
+
+const (
+	// operationPending operations are being resolved by the GraphQLService
+	operationPending operationState = iota
+	// operationRunning operations forward their payloads to the client
+	operationRunning
+	// operationCompleting operations were stopped and wind down
+	operationCompleting
+	// operationDone operations are over and no longer tracked
+	operationDone
+)
+
+type operation struct {
+	id     string
+	state  operationState
+	cancel context.CancelFunc
+	// stoppedByClient is set when the client stopped the operation, it knows
+	// the operation is over and gets no further message for it
+	stoppedByClient bool
+}
+
+var (
+	errOperationExists = errors.New("operation already exists")
+	errDraining        = errors.New("server shutting down")
+)
+
+// startOperation tracks a new pending operation. It returns errOperationExists
+// when an operation with the same id isn't done yet and errDraining once the
+// connection drains.
+func (c *wsConnection) startOperation(id string, cancel context.CancelFunc) (*operation, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.draining {
+		return nil, errDraining
+	}
+	if _, exists := c.active[id]; exists {
+		return nil, errOperationExists
+	}
+	op := &operation{id: id, state: operationPending, cancel: cancel}
+	c.active[id] = op
+	return op, nil
+}
+
+// runOperation moves a pending operation to running. Operations stopped while
+// they were pending stay completing.
+func (c *wsConnection) runOperation(op *operation) {
+	c.mu.Lock()
+	if op.state == operationPending {
+		op.state = operationRunning
+	}
+	c.mu.Unlock()
+}
+
+// stopOperation cancels a pending or running operation. It returns false when
+// the operation is unknown or already stopping, stopping twice is harmless.
+// The id of an operation the client stopped is free for reuse right away.
+func (c *wsConnection) stopOperation(id string, byClient bool) bool {
+	c.mu.Lock()
+	op := c.active[id]
+	if op == nil || op.state >= operationCompleting {
+		c.mu.Unlock()
+		return false
+	}
+	op.state = operationCompleting
+	if byClient {
+		op.stoppedByClient = true
+		delete(c.active, id)
+	}
+	c.mu.Unlock()
+
+	op.cancel()
+	return true
+}
+
+// finishOperation marks the operation done and frees its id
+func (c *wsConnection) finishOperation(op *operation) {
+	c.mu.Lock()
+	op.state = operationDone
+	if c.active[op.id] == op {
+		delete(c.active, op.id)
+	}
+	c.mu.Unlock()
+
+	op.cancel()
+}
+
+// operationIDs lists the ids of the operations that aren't done
+func (c *wsConnection) operationIDs() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ids := make([]string, 0, len(c.active))
+	for id := range c.active {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// drain refuses new operations, stops the running ones and closes the
+// connection once they are done or ctx is done
+func (c *wsConnection) drain(ctx context.Context, reason string) {
+	c.mu.Lock()
+	c.draining = true
+	c.mu.Unlock()
+
+	for _, id := range c.operationIDs() {
+		c.stopOperation(id, false)
+	}
+
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+wait:
+	for len(c.operationIDs()) != 0 {
+		select {
+		case <-ctx.Done():
+			break wait
+		case <-ticker.C:
+		}
+	}
+	c.close(websocket.CloseGoingAway, reason)
+}
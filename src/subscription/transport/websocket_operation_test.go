@@ -0,0 +1,151 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// gatedService blocks Subscribe until release is closed
+type gatedService struct {
+	*testService
+	subscribing chan struct{}
+	release     chan struct{}
+}
+
+func (s *gatedService) Subscribe(ctx context.Context, document string, operationName string, variableValues map[string]interface{}) (<-chan interface{}, error) {
+	s.subscribing <- struct{}{}
+	<-s.release
+	return s.testService.Subscribe(ctx, document, operationName, variableValues)
+}
+
+// readUntilComplete reads messages until the complete message of id, only
+// results and completions may be sent in between
+func readUntilComplete(t *testing.T, c *testClient, next string, id string) []map[string]interface{} {
+	t.Helper()
+
+	var received []map[string]interface{}
+	for {
+		msg, err := c.read()
+		if err != nil {
+			t.Fatalf("waiting for complete %s: %v", id, err)
+		}
+		if msg["type"] != next && msg["type"] != "complete" {
+			t.Fatalf("unexpected message %v", msg)
+		}
+		received = append(received, msg)
+		if msg["type"] == "complete" && msg["id"] == id {
+			return received
+		}
+	}
+}
+
+func connectionID(t *testing.T, registry *Registry) string {
+	t.Helper()
+
+	conns := registry.Connections()
+	if len(conns) != 1 {
+		t.Fatalf("%d connections registered, want 1", len(conns))
+	}
+	return conns[0].ID
+}
+
+func TestRestartingAnOperationRepeatedly(t *testing.T) {
+	forEachSubprotocol(t, func(t *testing.T, p subprotocol) {
+		service := &testService{}
+		c := dialTest(t, newTestServer(t, Websocket{}, service), p.name)
+		c.init()
+
+		for i := 0; i < 200; i++ {
+			c.operation(p.subscribe, "1", tickSubscription, "Tick")
+			c.send(p.stop, "1", nil)
+		}
+		c.operation(p.subscribe, "1", tickSubscription, "Tick")
+		c.operation(p.subscribe, "probe", helloQuery, "Hello")
+
+		// the stopped operations may have sent their result before the stop
+		// was read, nothing else
+		for _, msg := range readUntilComplete(t, c, p.next, "probe") {
+			if msg["type"] == "complete" && msg["id"] != "probe" {
+				t.Errorf("stopped operation completed: %v", msg)
+			}
+		}
+		service.waitRunning(t, 1)
+	})
+}
+
+func TestStoppingAPendingOperation(t *testing.T) {
+	forEachSubprotocol(t, func(t *testing.T, p subprotocol) {
+		registry := NewRegistry()
+		service := &gatedService{
+			testService: &testService{},
+			subscribing: make(chan struct{}),
+			release:     make(chan struct{}),
+		}
+		c := dialTest(t, newTestServer(t, Websocket{Registry: registry}, service), p.name)
+		c.init()
+
+		c.operation(p.subscribe, "1", tickSubscription, "Tick")
+		<-service.subscribing
+		id := connectionID(t, registry)
+		if !registry.StopOperation(id, "1") {
+			t.Fatal("pending operation not stopped")
+		}
+		if registry.StopOperation(id, "1") {
+			t.Error("operation stopped twice")
+		}
+		close(service.release)
+
+		// the operation never runs, its id is freed once it completes
+		readUntilComplete(t, c, p.next, "1")
+		service.waitRunning(t, 0)
+		if ops := registry.Connections()[0].Operations; len(ops) != 0 {
+			t.Errorf("operations %v still tracked", ops)
+		}
+		// release is closed, the next Subscribe only reports it started
+		go func() { <-service.subscribing }()
+		c.operation(p.subscribe, "1", tickSubscription, "Tick")
+		c.expect(p.next, "1")
+	})
+}
+
+func TestClientAndServerStoppingOperationsConcurrently(t *testing.T) {
+	forEachSubprotocol(t, func(t *testing.T, p subprotocol) {
+		const n = 50
+		registry := NewRegistry()
+		service := &testService{}
+		c := dialTest(t, newTestServer(t, Websocket{Registry: registry}, service), p.name)
+		c.init()
+		for i := 0; i < n; i++ {
+			c.operation(p.subscribe, fmt.Sprint(i), tickSubscription, "Tick")
+		}
+		service.waitRunning(t, n)
+		id := connectionID(t, registry)
+
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				registry.StopOperation(id, fmt.Sprint(i))
+			}
+		}()
+		for i := 0; i < n; i++ {
+			c.send(p.stop, fmt.Sprint(i), nil)
+		}
+		wg.Wait()
+
+		service.waitRunning(t, 0)
+		deadline := time.Now().Add(2 * time.Second)
+		for len(registry.Connections()[0].Operations) != 0 {
+			if time.Now().After(deadline) {
+				t.Fatalf("operations %v still tracked", registry.Connections()[0].Operations)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		c.operation(p.subscribe, "probe", helloQuery, "Hello")
+		readUntilComplete(t, c, p.next, "probe")
+	})
+}
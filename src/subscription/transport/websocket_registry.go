@@ -0,0 +1,281 @@
+package transport
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// A private key for context that only this package can access. This is important
+// to prevent collisions between different context uses
+var operationCtxKey = &wsOperationContextKey{"operation"}
+
+type wsOperationContextKey struct {
+	name string
+}
+
+type operationRef struct {
+	connectionID string
+	id           string
+}
+
+func withOperation(ctx context.Context, connectionID string, id string) context.Context {
+	return context.WithValue(ctx, operationCtxKey, operationRef{connectionID: connectionID, id: id})
+}
+
+// GetConnectionID returns the id of the websocket connection or HTTP stream an
+// operation was started on, or an empty string outside of an operation.
+func GetConnectionID(ctx context.Context) string {
+	op, _ := ctx.Value(operationCtxKey).(operationRef)
+	return op.connectionID
+}
+
+// GetOperationID returns the id the client gave to the operation, or an empty
+// string outside of an operation and for streams of a single operation.
+func GetOperationID(ctx context.Context) string {
+	op, _ := ctx.Value(operationCtxKey).(operationRef)
+	return op.id
+}
+
+// ConnectionInfo describes an open websocket connection or HTTP stream
+type ConnectionInfo struct {
+	ID         string `json:"id"`
+	RemoteAddr string `json:"remoteAddr"`
+	// Subprotocol is the websocket subprotocol, or the transport of HTTP
+	// streams: sse, multipart or long-poll
+	Subprotocol string    `json:"subprotocol"`
+	StartedAt   time.Time `json:"startedAt"`
+	Operations  []string  `json:"operations"`
+}
+
+// Registry tracks the open websocket connections and HTTP streams of the
+// transports sharing it so operators can list them and terminate connections
+// or single operations. The transports get it through their Registry field,
+// once it shuts down they refuse new connections and operations and HTTP
+// streams end.
+type Registry struct {
+	mu       sync.Mutex
+	conns    map[string]*wsConnection
+	streams  map[string]*httpStream
+	draining bool
+	// shutdown is cancelled by Shutdown
+	shutdown context.Context
+	cancel   context.CancelFunc
+}
+
+func NewRegistry() *Registry {
+	shutdown, cancel := context.WithCancel(context.Background())
+	return &Registry{
+		conns:    map[string]*wsConnection{},
+		streams:  map[string]*httpStream{},
+		shutdown: shutdown,
+		cancel:   cancel,
+	}
+}
+
+// isDraining reports whether Shutdown was called, new connections and
+// operations are refused
+func (r *Registry) isDraining() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.draining
+}
+
+// untilShutdown returns a copy of ctx cancelled when Shutdown is called, r may
+// be nil. HTTP streams aren't hijacked, the HTTP server waits for them to end
+// before it shuts down.
+func (r *Registry) untilShutdown(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	if r == nil {
+		return ctx, cancel
+	}
+	stop := context.AfterFunc(r.shutdown, cancel)
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
+
+// add tracks the connection, it returns false once the registry is draining
+func (r *Registry) add(c *wsConnection) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.draining {
+		return false
+	}
+	r.conns[c.id] = c
+	return true
+}
+
+func (r *Registry) remove(c *wsConnection) {
+	r.mu.Lock()
+	delete(r.conns, c.id)
+	r.mu.Unlock()
+}
+
+func (r *Registry) get(id string) *wsConnection {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.conns[id]
+}
+
+// httpStream is a connection of an HTTP transport: an SSE or multipart
+// response, a single connection mode SSE stream or a long-poll subscription
+type httpStream struct {
+	id         string
+	remoteAddr string
+	transport  string
+	startedAt  time.Time
+	// operations lists the ids of the running operations
+	operations func() []string
+	// stopOperation stops an operation, it returns false when it is unknown
+	stopOperation func(id string) bool
+	// close ends the stream and its operations
+	close func()
+}
+
+// singleOperationStream is the stream of an HTTP transport running a single
+// operation, the operation has an empty id and stops with cancel
+func singleOperationStream(id string, r *http.Request, transport string, cancel context.CancelFunc) *httpStream {
+	return &httpStream{
+		id:         id,
+		remoteAddr: r.RemoteAddr,
+		transport:  transport,
+		startedAt:  time.Now(),
+		operations: func() []string { return []string{""} },
+		stopOperation: func(id string) bool {
+			if id != "" {
+				return false
+			}
+			cancel()
+			return true
+		},
+		close: cancel,
+	}
+}
+
+// track lists the HTTP stream until the returned func is called, r may be nil
+func (r *Registry) track(s *httpStream) (untrack func()) {
+	if r == nil {
+		return func() {}
+	}
+
+	r.mu.Lock()
+	r.streams[s.id] = s
+	r.mu.Unlock()
+	return func() {
+		r.mu.Lock()
+		delete(r.streams, s.id)
+		r.mu.Unlock()
+	}
+}
+
+func (r *Registry) getStream(id string) *httpStream {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.streams[id]
+}
+
+// Connections lists the open connections and HTTP streams, oldest first
+func (r *Registry) Connections() []ConnectionInfo {
+	r.mu.Lock()
+	conns := make([]*wsConnection, 0, len(r.conns))
+	for _, c := range r.conns {
+		conns = append(conns, c)
+	}
+	streams := make([]*httpStream, 0, len(r.streams))
+	for _, s := range r.streams {
+		streams = append(streams, s)
+	}
+	r.mu.Unlock()
+
+	infos := make([]ConnectionInfo, 0, len(conns))
+	for _, c := range conns {
+		info := ConnectionInfo{
+			ID:          c.id,
+			RemoteAddr:  c.conn.RemoteAddr().String(),
+			Subprotocol: c.conn.Subprotocol(),
+			StartedAt:   c.startedAt,
+			Operations:  c.operationIDs(),
+		}
+		sort.Strings(info.Operations)
+		infos = append(infos, info)
+	}
+	for _, s := range streams {
+		info := ConnectionInfo{
+			ID:          s.id,
+			RemoteAddr:  s.remoteAddr,
+			Subprotocol: s.transport,
+			StartedAt:   s.startedAt,
+			Operations:  s.operations(),
+		}
+		sort.Strings(info.Operations)
+		infos = append(infos, info)
+	}
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].StartedAt.Before(infos[j].StartedAt) })
+	return infos
+}
+
+// CloseConnection closes the connection, or ends the HTTP stream, and every
+// operation running on it. It returns false when the connection is unknown.
+func (r *Registry) CloseConnection(id string) bool {
+	c := r.get(id)
+	if c == nil {
+		if s := r.getStream(id); s != nil {
+			s.close()
+			return true
+		}
+		return false
+	}
+
+	c.close(websocket.ClosePolicyViolation, "terminated by operator")
+	return true
+}
+
+// StopOperation stops an operation, websocket clients receive a complete
+// message. It returns false when the connection or the operation is unknown or
+// the operation is already stopping.
+func (r *Registry) StopOperation(connectionID string, operationID string) bool {
+	c := r.get(connectionID)
+	if c == nil {
+		if s := r.getStream(connectionID); s != nil {
+			return s.stopOperation(operationID)
+		}
+		return false
+	}
+
+	return c.stopOperation(operationID, false)
+}
+
+// Shutdown refuses new connections and operations, stops the operations of
+// every open connection so clients receive a complete message for each of
+// them, then closes the connections with a going away close code and reason.
+// Connections still winding down their operations when ctx is done are closed
+// right away, Shutdown returns ctx.Err() then. The streams of HTTP transports
+// sharing the registry end right away.
+func (r *Registry) Shutdown(ctx context.Context, reason string) error {
+	r.mu.Lock()
+	r.draining = true
+	conns := make([]*wsConnection, 0, len(r.conns))
+	for _, c := range r.conns {
+		conns = append(conns, c)
+	}
+	r.mu.Unlock()
+	r.cancel()
+
+	var wg sync.WaitGroup
+	for _, c := range conns {
+		wg.Add(1)
+		go func(c *wsConnection) {
+			defer wg.Done()
+			c.drain(ctx, reason)
+		}(c)
+	}
+	wg.Wait()
+	return ctx.Err()
+}
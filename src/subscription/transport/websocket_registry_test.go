@@ -0,0 +1,376 @@
+package transport
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestDrainingConnectionsRefuseOperations(t *testing.T) {
+	forEachSubprotocol(t, func(t *testing.T, p subprotocol) {
+		registry := NewRegistry()
+		c := dialTest(t, newTestServer(t, Websocket{Registry: registry}, &testService{}), p.name)
+		c.init()
+		c.operation(p.subscribe, "1", tickSubscription, "Tick")
+		c.expect(p.next, "1")
+
+		// as Shutdown does before it stops the running operations
+		conn := registry.get(connectionID(t, registry))
+		conn.mu.Lock()
+		conn.draining = true
+		conn.mu.Unlock()
+
+		c.operation(p.subscribe, "2", tickSubscription, "Tick")
+		msg := c.expect("error", "2")
+		var errs []struct {
+			Message string `json:"message"`
+		}
+		payloadOf(t, msg, &errs)
+		if len(errs) != 1 || errs[0].Message != "server shutting down" {
+			t.Errorf("got errors %v", msg["payload"])
+		}
+		if p.completesErrors {
+			c.expect("complete", "2")
+		}
+	})
+}
+
+func TestShutdownDrainsConnections(t *testing.T) {
+	forEachSubprotocol(t, func(t *testing.T, p subprotocol) {
+		registry := NewRegistry()
+		service := &testService{}
+		srv := newTestServer(t, Websocket{Registry: registry}, service)
+		c := dialTest(t, srv, p.name)
+		c.init()
+		c.operation(p.subscribe, "1", tickSubscription, "Tick")
+		c.expect(p.next, "1")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		if err := registry.Shutdown(ctx, "server shutting down"); err != nil {
+			t.Fatal(err)
+		}
+		c.expect("complete", "1")
+		c.expectClose(websocket.CloseGoingAway)
+		service.waitRunning(t, 0)
+
+		d := websocket.Dialer{Subprotocols: []string{p.name}}
+		if _, res, err := d.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil); err == nil || res.StatusCode != http.StatusServiceUnavailable {
+			t.Errorf("connected while draining: %v", err)
+		}
+	})
+}
+
+// stream reads the body of a streaming response in the background, done is
+// closed once the server ends it
+type stream struct {
+	first chan string
+	done  chan struct{}
+}
+
+func openStream(t *testing.T, req *http.Request) *stream {
+	t.Helper()
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = res.Body.Close() })
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("%s %s: status %d", req.Method, req.URL, res.StatusCode)
+	}
+
+	s := &stream{first: make(chan string, 1), done: make(chan struct{})}
+	go func() {
+		defer close(s.done)
+		r := bufio.NewReader(res.Body)
+		for {
+			line, err := r.ReadString('\n')
+			if strings.Contains(line, "tick") {
+				select {
+				case s.first <- line:
+				default:
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return s
+}
+
+func (s *stream) waitFirst(t *testing.T) {
+	t.Helper()
+
+	select {
+	case <-s.first:
+	case <-time.After(2 * time.Second):
+		t.Fatal("no result streamed")
+	}
+}
+
+func (s *stream) waitDone(t *testing.T) {
+	t.Helper()
+
+	select {
+	case <-s.done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("stream still open")
+	}
+}
+
+func newRequest(t *testing.T, method string, target string, body string) *http.Request {
+	t.Helper()
+
+	req, err := http.NewRequest(method, target, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return req
+}
+
+func do(t *testing.T, req *http.Request) *http.Response {
+	t.Helper()
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = res.Body.Close() })
+	return res
+}
+
+func TestShutdownEndsHTTPStreams(t *testing.T) {
+	registry := NewRegistry()
+	sse := &SSE{Registry: registry}
+	longPoll := &LongPoll{Registry: registry, PollTimeout: 10 * time.Second}
+	multipart := Multipart{Registry: registry}
+	service := &testService{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case longPoll.Supports(r):
+			longPoll.Do(w, r, service)
+		case sse.Supports(r):
+			sse.Do(w, r, service)
+		case multipart.Supports(r):
+			multipart.Do(w, r, service)
+		}
+	}))
+	defer srv.Close()
+	operation := `{"query": "subscription Tick { tick }", "operationName": "Tick"}`
+
+	// an SSE stream in distinct connections mode
+	req := newRequest(t, http.MethodPost, srv.URL, operation)
+	req.Header.Set("Accept", "text/event-stream")
+	distinct := openStream(t, req)
+	distinct.waitFirst(t)
+
+	// an SSE stream in single connection mode
+	token := reserveStream(t, srv)
+	single := openStream(t, streamRequest(t, http.MethodGet, srv.URL, token, ""))
+	req = streamRequest(t, http.MethodPost, srv.URL, token, `{"query": "subscription Tick { tick }", "operationName": "Tick", "extensions": {"operationId": "1"}}`)
+	if res := do(t, req); res.StatusCode != http.StatusAccepted {
+		t.Fatalf("execute: status %d", res.StatusCode)
+	}
+	single.waitFirst(t)
+
+	// a multipart response
+	req = newRequest(t, http.MethodPost, srv.URL, operation)
+	req.Header.Set("Accept", "multipart/mixed")
+	parts := openStream(t, req)
+	parts.waitFirst(t)
+
+	// a long-polling subscription waiting for its next payloads
+	res := do(t, newRequest(t, http.MethodPost, srv.URL+"?transport=long-poll", operation))
+	var subscribed struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&subscribed); err != nil {
+		t.Fatal(err)
+	}
+	var poll longPollResponse
+	res = do(t, newRequest(t, http.MethodGet, srv.URL+"?transport=long-poll&token="+subscribed.Token, ""))
+	if err := json.NewDecoder(res.Body).Decode(&poll); err != nil || len(poll.Payloads) != 1 {
+		t.Fatalf("first poll %+v: %v", poll, err)
+	}
+	polled := make(chan longPollResponse, 1)
+	go func() {
+		res, err := http.Get(srv.URL + "?transport=long-poll&ack=1&token=" + subscribed.Token)
+		if err != nil {
+			return
+		}
+		defer res.Body.Close()
+		var poll longPollResponse
+		_ = json.NewDecoder(res.Body).Decode(&poll)
+		polled <- poll
+	}()
+	service.waitRunning(t, 4)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := registry.Shutdown(ctx, "server shutting down"); err != nil {
+		t.Fatal(err)
+	}
+
+	distinct.waitDone(t)
+	single.waitDone(t)
+	parts.waitDone(t)
+	select {
+	case poll := <-polled:
+		if !poll.Complete {
+			t.Errorf("poll %+v isn't complete", poll)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("poll still waiting")
+	}
+	service.waitRunning(t, 0)
+
+	// new streams are refused
+	reservation := newRequest(t, http.MethodPut, srv.URL, "")
+	reservation.Header.Set("Accept", "text/plain")
+	for _, req := range []*http.Request{
+		reservation,
+		newRequest(t, http.MethodPost, srv.URL+"?transport=long-poll", operation),
+	} {
+		if res := do(t, req); res.StatusCode != http.StatusServiceUnavailable {
+			t.Errorf("%s %s: status %d, want 503", req.Method, req.URL, res.StatusCode)
+		}
+	}
+	req = newRequest(t, http.MethodPost, srv.URL, operation)
+	req.Header.Set("Accept", "multipart/mixed")
+	if res := do(t, req); res.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("multipart: status %d, want 503", res.StatusCode)
+	}
+
+	// nothing holds the HTTP server anymore
+	if err := srv.Config.Shutdown(ctx); err != nil {
+		t.Errorf("HTTP server shutdown: %s", err)
+	}
+}
+
+// connectionOf returns the connection of the registry served with transport
+func connectionOf(t *testing.T, registry *Registry, transport string) ConnectionInfo {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		for _, c := range registry.Connections() {
+			if c.Subprotocol == transport {
+				return c
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("no %s connection in %+v", transport, registry.Connections())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestOperatorsTerminateHTTPStreams(t *testing.T) {
+	registry := NewRegistry()
+	sse := &SSE{Registry: registry}
+	longPoll := &LongPoll{Registry: registry, PollTimeout: 10 * time.Second}
+	multipart := Multipart{Registry: registry}
+	service := &testService{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case longPoll.Supports(r):
+			longPoll.Do(w, r, service)
+		case sse.Supports(r):
+			sse.Do(w, r, service)
+		case multipart.Supports(r):
+			multipart.Do(w, r, service)
+		}
+	}))
+	defer srv.Close()
+	operation := `{"query": "subscription Tick { tick }", "operationName": "Tick"}`
+
+	// the operation of a distinct SSE stream
+	req := newRequest(t, http.MethodPost, srv.URL, operation)
+	req.Header.Set("Accept", "text/event-stream")
+	distinct := openStream(t, req)
+	distinct.waitFirst(t)
+	c := connectionOf(t, registry, "sse")
+	if len(c.Operations) != 1 || c.Operations[0] != "" {
+		t.Fatalf("operations %q, want the single one", c.Operations)
+	}
+	if registry.StopOperation(c.ID, "1") {
+		t.Error("stopped an unknown operation")
+	}
+	if !registry.StopOperation(c.ID, "") {
+		t.Fatal("operation not stopped")
+	}
+	distinct.waitDone(t)
+	service.waitRunning(t, 0)
+
+	// the operations of a single connection mode SSE stream, then the stream
+	token := reserveStream(t, srv)
+	single := openStream(t, streamRequest(t, http.MethodGet, srv.URL, token, ""))
+	for _, id := range []string{"1", "2"} {
+		req = streamRequest(t, http.MethodPost, srv.URL, token, `{"query": "subscription Tick { tick }", "operationName": "Tick", "extensions": {"operationId": "`+id+`"}}`)
+		if res := do(t, req); res.StatusCode != http.StatusAccepted {
+			t.Fatalf("execute: status %d", res.StatusCode)
+		}
+	}
+	service.waitRunning(t, 2)
+	c = connectionOf(t, registry, "sse")
+	if c.ID != token || len(c.Operations) != 2 {
+		t.Fatalf("got %+v, want stream %s with 2 operations", c, token)
+	}
+	if !registry.StopOperation(c.ID, "1") {
+		t.Fatal("operation not stopped")
+	}
+	service.waitRunning(t, 1)
+	if !registry.CloseConnection(c.ID) {
+		t.Fatal("stream not closed")
+	}
+	single.waitDone(t)
+	service.waitRunning(t, 0)
+
+	// a multipart response
+	req = newRequest(t, http.MethodPost, srv.URL, operation)
+	req.Header.Set("Accept", "multipart/mixed")
+	parts := openStream(t, req)
+	parts.waitFirst(t)
+	if !registry.CloseConnection(connectionOf(t, registry, "multipart").ID) {
+		t.Fatal("multipart response not closed")
+	}
+	parts.waitDone(t)
+	service.waitRunning(t, 0)
+
+	// a long-polling subscription completes
+	res := do(t, newRequest(t, http.MethodPost, srv.URL+"?transport=long-poll", operation))
+	var subscribed struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&subscribed); err != nil {
+		t.Fatal(err)
+	}
+	if !registry.CloseConnection(connectionOf(t, registry, "long-poll").ID) {
+		t.Fatal("long-poll subscription not closed")
+	}
+	service.waitRunning(t, 0)
+	var poll longPollResponse
+	res = do(t, newRequest(t, http.MethodGet, srv.URL+"?transport=long-poll&token="+subscribed.Token, ""))
+	if err := json.NewDecoder(res.Body).Decode(&poll); err != nil || !poll.Complete {
+		t.Fatalf("poll %+v isn't complete: %v", poll, err)
+	}
+
+	// ended streams are forgotten
+	deadline := time.Now().Add(2 * time.Second)
+	for len(registry.Connections()) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("connections left: %+v", registry.Connections())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,199 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// testService resolves operations without a schema, by operation name:
+//
+//   - queries and mutations get a single result
+//   - subscriptions get a result then stay open until they are stopped
+//   - Finite subscriptions get a result then complete
+//   - Fail operations are rejected by Subscribe
+//   - Invalid operations get a result with errors but no data, as documents
+//     failing validation do
+//   - Panic operations panic in Subscribe
+type testService struct {
+	// running counts the subscriptions that weren't stopped yet
+	running atomic.Int32
+}
+
+func (s *testService) Subscribe(ctx context.Context, document string, operationName string, variableValues map[string]interface{}) (<-chan interface{}, error) {
+	switch operationName {
+	case "Fail":
+		return nil, errors.New("subscribe failed")
+	case "Panic":
+		panic("subscribe panicked")
+	case "Invalid":
+		payloads := make(chan interface{}, 1)
+		payloads <- map[string]interface{}{"errors": []map[string]interface{}{{"message": "invalid operation"}}}
+		close(payloads)
+		return payloads, nil
+	}
+
+	payloads := make(chan interface{}, 1)
+	payloads <- map[string]interface{}{"data": map[string]interface{}{"tick": 1}}
+	if !strings.HasPrefix(document, "subscription") || operationName == "Finite" {
+		close(payloads)
+		return payloads, nil
+	}
+
+	s.running.Add(1)
+	go func() {
+		<-ctx.Done()
+		s.running.Add(-1)
+		close(payloads)
+	}()
+	return payloads, nil
+}
+
+// waitRunning waits for the number of running subscriptions to reach n
+func (s *testService) waitRunning(t *testing.T, n int32) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for s.running.Load() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d subscriptions running, want %d", s.running.Load(), n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// newTestServer serves service with ws until the test ends
+func newTestServer(t *testing.T, ws Websocket, service GraphQLService) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws.Do(w, r, service)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// testClient is a scripted websocket client
+type testClient struct {
+	t    *testing.T
+	conn *websocket.Conn
+}
+
+func dialTest(t *testing.T, srv *httptest.Server, subprotocol string) *testClient {
+	t.Helper()
+
+	d := websocket.Dialer{Subprotocols: []string{subprotocol}}
+	conn, _, err := d.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return &testClient{t: t, conn: conn}
+}
+
+func (c *testClient) sendRaw(msg string) {
+	c.t.Helper()
+
+	if err := c.conn.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *testClient) send(msgType string, id string, payload interface{}) {
+	c.t.Helper()
+
+	msg := map[string]interface{}{"type": msgType}
+	if id != "" {
+		msg["id"] = id
+	}
+	if payload != nil {
+		msg["payload"] = payload
+	}
+	if err := c.conn.WriteJSON(msg); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// operation sends a start message of operationName
+func (c *testClient) operation(msgType string, id string, document string, operationName string) {
+	c.t.Helper()
+
+	c.send(msgType, id, map[string]interface{}{"query": document, "operationName": operationName})
+}
+
+// read returns the next message, keep-alive messages are skipped
+func (c *testClient) read() (map[string]interface{}, error) {
+	for {
+		_ = c.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		var msg map[string]interface{}
+		if err := c.conn.ReadJSON(&msg); err != nil {
+			return nil, err
+		}
+		if msg["type"] != "ka" {
+			return msg, nil
+		}
+	}
+}
+
+// expect reads the next message and checks its type and id
+func (c *testClient) expect(msgType string, id string) map[string]interface{} {
+	c.t.Helper()
+
+	msg, err := c.read()
+	if err != nil {
+		c.t.Fatalf("expected %s, got %v", msgType, err)
+	}
+	if msg["type"] != msgType || (id != "" && msg["id"] != id) {
+		c.t.Fatalf("expected %s for %q, got %v", msgType, id, msg)
+	}
+	return msg
+}
+
+// expectClose checks the server sends the messages of before then closes the
+// connection with code
+func (c *testClient) expectClose(code int, before ...string) {
+	c.t.Helper()
+
+	for _, msgType := range before {
+		c.expect(msgType, "")
+	}
+	msg, err := c.read()
+	if err == nil {
+		c.t.Fatalf("expected close %d, got %v", code, msg)
+	}
+	var closeErr *websocket.CloseError
+	if !errors.As(err, &closeErr) {
+		c.t.Fatalf("expected close %d, got %v", code, err)
+	}
+	if closeErr.Code != code {
+		c.t.Fatalf("closed with %d %q, want %d", closeErr.Code, closeErr.Text, code)
+	}
+}
+
+func (c *testClient) init() {
+	c.t.Helper()
+
+	c.send("connection_init", "", nil)
+	c.expect("connection_ack", "")
+}
+
+// payloadOf decodes the payload of msg into v
+func payloadOf(t *testing.T, msg map[string]interface{}, v interface{}) {
+	t.Helper()
+
+	b, err := json.Marshal(msg["payload"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		t.Fatal(err)
+	}
+}